@@ -5,10 +5,15 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/mail"
 	"github.com/PRASHANTSWAROOP001/notes-app/internal/middleware"
 	"github.com/PRASHANTSWAROOP001/notes-app/internal/notes"
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/storage"
 	"github.com/PRASHANTSWAROOP001/notes-app/internal/user"
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/workspace"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -21,28 +26,169 @@ func main() {
 	}
 	defer db.Close()
 
+	// APP_URL is where links in emails point.
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" {
+		appURL = "http://localhost:8080"
+	}
+
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "Notes <no-reply@localhost>"
+	}
+
+	// Mail goes over SMTP when SMTP_HOST is set, and into an outbox
+	// directory of .eml files otherwise.
+	var mailer mail.Mailer
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := 587
+		if v := os.Getenv("SMTP_PORT"); v != "" {
+			port, err = strconv.Atoi(v)
+			if err != nil {
+				log.Fatal("invalid SMTP_PORT:", err)
+			}
+		}
+
+		mailer = mail.NewSMTPMailer(mail.SMTPConfig{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     mailFrom,
+		})
+	} else {
+		outboxDir := os.Getenv("MAIL_OUTBOX_DIR")
+		if outboxDir == "" {
+			outboxDir = "./data/outbox"
+		}
+
+		mailer, err = mail.NewOutboxMailer(outboxDir, mailFrom)
+		if err != nil {
+			log.Fatal("mail outbox init failed:", err)
+		}
+	}
+
+	mailQueue := mail.NewQueue(mailer, 1000, 5)
+	go mailQueue.Run(context.Background(), 2)
+
+	if os.Getenv("TOTP_ENCRYPTION_KEY") == "" {
+		log.Println("TOTP_ENCRYPTION_KEY is not set, two-factor enrollment is disabled")
+	}
+
 	repo := user.NewPostgresUserRepository(db)
-	svc := user.NewService(repo)
+	svc := user.NewService(repo, mailQueue, appURL)
 	h := user.NewHandler(svc)
 
+	// access tokens of logged-out sessions stop working straight away
+	middleware.CheckSessions(svc)
+
+	// API keys work on routes that name a scope, and only with that scope;
+	// managing accounts and keys still takes a login
+	middleware.CheckAPIKeys(svc)
+
 	notesRepo := notes.NewPostgresNotesRepository(db)
 
-	notesSvc := notes.NewNotesService(notesRepo)
+	blobDir := os.Getenv("BLOB_DIR")
+	if blobDir == "" {
+		blobDir = "./data/blobs"
+	}
+
+	blobs, err := storage.NewLocalBlobStore(blobDir)
+	if err != nil {
+		log.Fatal("blob store init failed:", err)
+	}
+
+	// views are written in batches of up to 500, at least every 5 seconds;
+	// viewer keys are hashed with JWT_SECRET, so rotating it only restarts
+	// unique viewer counts
+	viewRecorder := notes.NewViewRecorder(notesRepo, os.Getenv("JWT_SECRET"), 10000, 500, 5*time.Second)
+	go viewRecorder.Run(context.Background())
+
+	notesSvc := notes.NewNotesService(notesRepo, blobs, notes.DefaultAttachmentLimits, mailQueue, appURL, viewRecorder)
+
+	// TRASH_RETENTION takes a Go duration such as "720h"; notes stay in the
+	// trash for 30 days by default.
+	retention := 30 * 24 * time.Hour
+	if v := os.Getenv("TRASH_RETENTION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatal("invalid TRASH_RETENTION:", err)
+		}
+		retention = d
+	}
+
+	go notes.NewTrashPurger(notesRepo, blobs, retention, time.Hour).Run(context.Background())
 
 	notesHandler := notes.NewNotehandler(notesSvc)
 
+	workspaceRepo := workspace.NewPostgresRepository(db)
+	workspaceHandler := workspace.NewHandler(workspace.NewService(workspaceRepo))
+
 	http.HandleFunc("/auth/register", h.Register)
 	http.HandleFunc("/auth/login", h.Login)
+	http.HandleFunc("/auth/login/mfa", h.LoginMFA)
+	http.HandleFunc("/auth/refresh", h.Refresh)
+	http.HandleFunc("/auth/forgot-password", h.ForgotPassword)
+	http.HandleFunc("/auth/reset-password", h.ResetPassword)
+	http.HandleFunc("/auth/verify-email", h.VerifyEmail)
+	http.Handle("/auth/resend-verification", middleware.AuthMiddleware(http.HandlerFunc(h.ResendVerification)))
+	http.Handle("/auth/logout", middleware.AuthMiddleware(http.HandlerFunc(h.Logout)))
+	http.Handle("/auth/logout-everywhere", middleware.AuthMiddleware(http.HandlerFunc(h.LogoutEverywhere)))
+	http.Handle("/auth/2fa/setup", middleware.AuthMiddleware(http.HandlerFunc(h.SetupMFA)))
+	http.Handle("/auth/2fa/confirm", middleware.AuthMiddleware(http.HandlerFunc(h.ConfirmMFA)))
+	http.Handle("/auth/2fa/disable", middleware.AuthMiddleware(http.HandlerFunc(h.DisableMFA)))
+	http.Handle("/auth/api-keys", middleware.AuthMiddleware(http.HandlerFunc(h.ListAPIKeys)))
+	http.Handle("/auth/api-keys/create", middleware.AuthMiddleware(http.HandlerFunc(h.CreateAPIKey)))
+	http.Handle("/auth/api-keys/revoke", middleware.AuthMiddleware(http.HandlerFunc(h.RevokeAPIKey)))
 
-	http.Handle("/notes/create-note", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.CreateNote)))
-	http.Handle("/notes/get-notes", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.GetUserNotes)))
-	http.Handle("/notes/get-note", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.GetUserNoteById)))
-	http.Handle("/notes/delete", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.DeleteNote)))
-    http.Handle("/notes/update", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.UpdateNote)))
-	http.Handle("/notes/share-slug", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.ShareWithEmail)))
-	http.Handle("/notes", middleware.OptionalMiddleware(http.HandlerFunc(notesHandler.GetPublicAccess)))
-	http.Handle("/notes/revoke-access", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.RemoveEmailShare)))
+	http.Handle("/notes/create-note", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.CreateNote), middleware.ScopeNotesWrite))
+	http.Handle("/notes/get-notes", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.GetUserNotes), middleware.ScopeNotesRead))
+	http.Handle("/notes/shared-with-me", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.GetSharedWithMe), middleware.ScopeNotesRead))
+	http.Handle("/notes/get-note", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.GetUserNoteById), middleware.ScopeNotesRead))
+	http.Handle("/notes/delete", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.DeleteNote), middleware.ScopeNotesWrite))
+    http.Handle("/notes/update", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.UpdateNote), middleware.ScopeNotesWrite))
+	http.Handle("/notes/share-slug", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.ShareWithEmail), middleware.ScopeSharesManage))
+	http.Handle("/notes", middleware.OptionalMiddleware(http.HandlerFunc(notesHandler.GetPublicAccess), middleware.ScopeNotesRead))
+	http.Handle("/notes/revoke-access", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.RemoveEmailShare), middleware.ScopeSharesManage))
 	http.HandleFunc("/notes/public", notesHandler.GetPublicAccess)
+	http.Handle("/notes/tags", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.ListTags), middleware.ScopeNotesRead))
+	http.Handle("/notes/tags/rename", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.RenameTag), middleware.ScopeNotesWrite))
+	http.Handle("/notes/tags/merge", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.MergeTags), middleware.ScopeNotesWrite))
+	http.Handle("/notes/move", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.MoveNote), middleware.ScopeNotesWrite))
+	http.Handle("/notes/folders", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.GetFolderTree), middleware.ScopeNotesRead))
+	http.Handle("/notes/folders/create", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.CreateFolder), middleware.ScopeNotesWrite))
+	http.Handle("/notes/folders/move", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.MoveFolder), middleware.ScopeNotesWrite))
+	http.Handle("/notes/folders/delete", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.DeleteFolder), middleware.ScopeNotesWrite))
+	http.Handle("/notes/trash", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.ListTrash), middleware.ScopeNotesRead))
+	http.Handle("/notes/trash/restore", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.RestoreNote), middleware.ScopeNotesWrite))
+	http.Handle("/notes/trash/empty", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.EmptyTrash), middleware.ScopeNotesWrite))
+	http.Handle("/notes/attachments", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.ListAttachments), middleware.ScopeNotesRead))
+	http.Handle("/notes/attachments/upload", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.UploadAttachment), middleware.ScopeNotesWrite))
+	http.Handle("/notes/attachments/download", middleware.OptionalMiddleware(http.HandlerFunc(notesHandler.DownloadAttachment), middleware.ScopeNotesRead))
+	http.Handle("/notes/attachments/delete", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.DeleteAttachment), middleware.ScopeNotesWrite))
+	http.Handle("/notes/collaborators", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.Collaborators), middleware.ScopeSharesManage))
+	http.Handle("/notes/password", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.SetNotePassword), middleware.ScopeSharesManage))
+	http.Handle("/notes/slug", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.SetNoteSlug), middleware.ScopeSharesManage))
+	http.Handle("/notes/stats", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.GetViewStats), middleware.ScopeNotesRead))
+	http.Handle("/notes/links", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.ListShareLinks), middleware.ScopeSharesManage))
+	http.Handle("/notes/links/create", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.CreateShareLink), middleware.ScopeSharesManage))
+	http.Handle("/notes/links/revoke", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.RevokeShareLink), middleware.ScopeSharesManage))
+	http.Handle("/notes/transfers", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.ListTransfers), middleware.ScopeSharesManage))
+	http.Handle("/notes/transfers/propose", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.ProposeTransfer), middleware.ScopeSharesManage))
+	http.Handle("/notes/transfers/accept", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.AcceptTransfer), middleware.ScopeSharesManage))
+	http.Handle("/notes/transfers/cancel", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.CancelTransfer), middleware.ScopeSharesManage))
+	http.Handle("/notes/search", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.SearchNotes), middleware.ScopeNotesRead))
+	http.Handle("/notes/revisions", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.ListRevisions), middleware.ScopeNotesRead))
+	http.Handle("/notes/revision", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.GetRevision), middleware.ScopeNotesRead))
+	http.Handle("/notes/revisions/diff", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.DiffRevisions), middleware.ScopeNotesRead))
+	http.Handle("/notes/revisions/restore", middleware.AuthMiddleware(http.HandlerFunc(notesHandler.RestoreRevision), middleware.ScopeNotesWrite))
+	http.Handle("/workspaces", middleware.AuthMiddleware(http.HandlerFunc(workspaceHandler.ListWorkspaces)))
+	http.Handle("/workspaces/create", middleware.AuthMiddleware(http.HandlerFunc(workspaceHandler.CreateWorkspace)))
+	http.Handle("/workspaces/delete", middleware.AuthMiddleware(http.HandlerFunc(workspaceHandler.DeleteWorkspace)))
+	http.Handle("/workspaces/members", middleware.AuthMiddleware(http.HandlerFunc(workspaceHandler.ListMembers)))
+	http.Handle("/workspaces/members/add", middleware.AuthMiddleware(http.HandlerFunc(workspaceHandler.AddMember)))
+	http.Handle("/workspaces/members/role", middleware.AuthMiddleware(http.HandlerFunc(workspaceHandler.SetMemberRole)))
+	http.Handle("/workspaces/members/remove", middleware.AuthMiddleware(http.HandlerFunc(workspaceHandler.RemoveMember)))
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("OK"))
 	})
@@ -0,0 +1,100 @@
+package mail
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	// sendTimeout bounds a single delivery attempt.
+	sendTimeout = 30 * time.Second
+
+	// retryBase is the wait after the first failure; it doubles each time.
+	retryBase = 5 * time.Second
+)
+
+type queuedMessage struct {
+	msg     Message
+	attempt int
+}
+
+// Queue is a Mailer that returns as soon as a message is queued and delivers
+// it in the background through another Mailer, retrying failures with
+// exponential backoff. Messages still queued when the process stops are lost.
+type Queue struct {
+	next     Mailer
+	attempts int
+	jobs     chan queuedMessage
+}
+
+// NewQueue returns a queue holding up to size messages, each tried at most
+// attempts times. Nothing is sent until Run is started.
+func NewQueue(next Mailer, size, attempts int) *Queue {
+	return &Queue{
+		next:     next,
+		attempts: max(attempts, 1),
+		jobs:     make(chan queuedMessage, size),
+	}
+}
+
+// Send queues msg. It never blocks: when the queue is full the message is
+// dropped and ErrQueueFull returned.
+func (q *Queue) Send(ctx context.Context, msg Message) error {
+	return q.enqueue(queuedMessage{msg: msg})
+}
+
+func (q *Queue) enqueue(job queuedMessage) error {
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		log.Printf("mail queue full, dropping %q to %v", job.msg.Subject, job.msg.To)
+		return ErrQueueFull
+	}
+}
+
+// Run delivers queued messages with the given number of workers until ctx
+// is done. It is meant to be started in its own goroutine.
+func (q *Queue) Run(ctx context.Context, workers int) {
+	for range max(workers, 1) - 1 {
+		go q.work(ctx)
+	}
+	q.work(ctx)
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-q.jobs:
+			q.deliver(ctx, job)
+		}
+	}
+}
+
+func (q *Queue) deliver(ctx context.Context, job queuedMessage) {
+	sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+	err := q.next.Send(sendCtx, job.msg)
+	cancel()
+
+	if err == nil {
+		return
+	}
+
+	job.attempt++
+	if job.attempt >= q.attempts {
+		log.Printf("giving up on mail %q to %v after %d attempts: %v", job.msg.Subject, job.msg.To, job.attempt, err)
+		return
+	}
+
+	// wait off the worker so other mail keeps flowing
+	delay := retryBase << (job.attempt - 1)
+	log.Printf("mail %q to %v failed, retrying in %s: %v", job.msg.Subject, job.msg.To, delay, err)
+	time.AfterFunc(delay, func() {
+		if ctx.Err() == nil {
+			q.enqueue(job)
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// Render builds the message for the named template in templates/. The .txt
+// file holds the text body and defines the subject in a "subject" block; the
+// .html file holds the HTML body, escaped by html/template.
+func Render(name, to string, data any) (Message, error) {
+	text, err := texttemplate.ParseFS(templateFS, "templates/"+name+".txt")
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to load mail template %s: %w", name, err)
+	}
+
+	html, err := htmltemplate.ParseFS(templateFS, "templates/"+name+".html")
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to load mail template %s: %w", name, err)
+	}
+
+	var subject, textBody, htmlBody bytes.Buffer
+
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, fmt.Errorf("failed to render mail subject %s: %w", name, err)
+	}
+	if err := text.Execute(&textBody, data); err != nil {
+		return Message{}, fmt.Errorf("failed to render mail template %s: %w", name, err)
+	}
+	if err := html.Execute(&htmlBody, data); err != nil {
+		return Message{}, fmt.Errorf("failed to render mail template %s: %w", name, err)
+	}
+
+	return Message{
+		To:      []string{to},
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(textBody.String()) + "\n",
+		HTML:    htmlBody.String(),
+	}, nil
+}
+
+// ShareInvite is the data for the share_invite template.
+type ShareInvite struct {
+	OwnerName string
+	NoteTitle string
+	Role      string
+	Link      string
+}
+
+// ShareRevoked is the data for the share_revoked template.
+type ShareRevoked struct {
+	OwnerName string
+	NoteTitle string
+}
+
+// TransferOffer is the data for the transfer_offer template. NoteTitle is
+// only used when a single note is offered.
+type TransferOffer struct {
+	OwnerName string
+	NoteTitle string
+	Count     int
+	Link      string
+}
+
+// Welcome is the data for the welcome template, sent after registration.
+// It doubles as the first email verification request.
+type Welcome struct {
+	Name       string
+	AppURL     string
+	VerifyLink string
+	ExpiresIn  string
+}
+
+// VerifyEmail is the data for the verify_email template, sent when a user
+// asks for a new verification link.
+type VerifyEmail struct {
+	Name       string
+	VerifyLink string
+	ExpiresIn  string
+}
+
+// PasswordReset is the data for the password_reset template. ExpiresIn is
+// how long the link stays valid, in words.
+type PasswordReset struct {
+	Name      string
+	Link      string
+	ExpiresIn string
+}
+
+// SendBestEffort renders the named template and sends it through m, for mail
+// that follows a change already saved: failures are logged, not returned. A
+// nil m sends nothing.
+func SendBestEffort(ctx context.Context, m Mailer, template, to string, data any) {
+	if m == nil {
+		return
+	}
+
+	msg, err := Render(template, to, data)
+	if err != nil {
+		log.Printf("%s mail to %s not sent: %v", template, to, err)
+		return
+	}
+
+	if err := m.Send(ctx, msg); err != nil {
+		log.Printf("%s mail to %s not sent: %v", template, to, err)
+	}
+}
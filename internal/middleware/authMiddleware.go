@@ -2,8 +2,10 @@ package middleware
 
 import (
 	"context"
+	"log"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -12,50 +14,92 @@ import (
 type contextKey string
 
 const (
-	userIDKey contextKey = "user_id"
-	emailKey  contextKey = "email"
+	userIDKey    contextKey = "user_id"
+	emailKey     contextKey = "email"
+	sessionIDKey contextKey = "session_id"
 )
 
+// Scope is a permission an API key can carry. Logins are not scoped; a key
+// only reaches routes that name one of its scopes.
+type Scope string
+
+const (
+	ScopeNotesRead    Scope = "notes:read"
+	ScopeNotesWrite   Scope = "notes:write"
+	ScopeSharesManage Scope = "shares:manage"
+)
+
+// ValidScope reports whether s names a known scope.
+func ValidScope(s string) bool {
+	switch Scope(s) {
+	case ScopeNotesRead, ScopeNotesWrite, ScopeSharesManage:
+		return true
+	}
+	return false
+}
+
+// APIKeyPrefix starts every API key, which is how a bearer token is told
+// apart from an access token.
+const APIKeyPrefix = "nk_"
+
+// APIKeyOwner is who an API key acts for and what it may do.
+type APIKeyOwner struct {
+	UserID string
+	Email  string
+	Scopes []Scope
+}
+
+// APIKeyChecker resolves an API key, failing if it is unknown or expired.
+type APIKeyChecker interface {
+	CheckAPIKey(ctx context.Context, key string) (*APIKeyOwner, error)
+}
+
+var apiKeys APIKeyChecker
+
+// CheckAPIKeys makes both middlewares accept API keys as bearer tokens.
+// Call it once at startup, before serving.
+func CheckAPIKeys(c APIKeyChecker) {
+	apiKeys = c
+}
+
+// SessionChecker reports whether a login session is still live.
+type SessionChecker interface {
+	SessionActive(ctx context.Context, userID, sessionID string) (bool, error)
+}
+
+var sessions SessionChecker
+
+// CheckSessions makes both middlewares turn away access tokens whose
+// session has been logged out. Call it once at startup, before serving;
+// until then any validly signed token is accepted.
+func CheckSessions(c SessionChecker) {
+	sessions = c
+}
+
 
 // ------------------------------------------------------------
 // STRICT AUTH MIDDLEWARE (Requires Login)
 // ------------------------------------------------------------
-func AuthMiddleware(next http.Handler) http.Handler {
+
+// AuthMiddleware requires a logged-in caller. API keys get through only if
+// they carry every one of scopes; routes that name no scopes refuse keys.
+func AuthMiddleware(next http.Handler, scopes ...Scope) http.Handler {
 	secret := []byte(os.Getenv("JWT_SECRET"))
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (any, error) {
-			return secret, nil
-		})
-
-		if err != nil || !token.Valid {
+		id, ok := authenticate(r, secret)
+		if !ok {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		if !id.allows(scopes) {
+			http.Error(w, "Forbidden: API key lacks the required scope", http.StatusForbidden)
 			return
 		}
 
-		userID, _ := claims["user_id"].(string)
-		email, _ := claims["email"].(string)
-
-		// Attach to context
-		ctx := context.WithValue(r.Context(), userIDKey, userID)
-		ctx = context.WithValue(ctx, emailKey, email)
-
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r.WithContext(id.attach(r.Context())))
 	})
 }
 
@@ -64,52 +108,150 @@ func AuthMiddleware(next http.Handler) http.Handler {
 // ------------------------------------------------------------
 // OPTIONAL AUTH MIDDLEWARE (Public Routes + Logged-in Upgrade)
 // ------------------------------------------------------------
-func OptionalMiddleware(next http.Handler) http.Handler {
+
+// OptionalMiddleware identifies the caller if it can. API keys are held to
+// scopes as in AuthMiddleware.
+func OptionalMiddleware(next http.Handler, scopes ...Scope) http.Handler {
 	secret := []byte(os.Getenv("JWT_SECRET"))
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		authHeader := r.Header.Get("Authorization")
-
-		// ✅ No token → proceed as anonymous
-		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
+		// ✅ No token, or one that is invalid or logged out → proceed as anonymous
+		id, ok := authenticate(r, secret)
+		if !ok {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+		if !id.allows(scopes) {
+			http.Error(w, "Forbidden: API key lacks the required scope", http.StatusForbidden)
+			return
+		}
 
-		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (any, error) {
-			return secret, nil
-		})
+		next.ServeHTTP(w, r.WithContext(id.attach(r.Context())))
+	})
+}
 
-		// ✅ Invalid token → treat as anonymous
-		if err != nil || !token.Valid {
-			next.ServeHTTP(w, r)
-			return
+
+
+// ------------------------------------------------------------
+// TOKEN CHECKS
+// ------------------------------------------------------------
+
+// identity is who a request's bearer token says the caller is. apiKey is
+// set when it was an API key, which can only do what scopes allow.
+type identity struct {
+	userID    string
+	email     string
+	sessionID string
+
+	apiKey bool
+	scopes []Scope
+}
+
+// authenticate checks the bearer token on r: its signature, its expiry and,
+// once CheckSessions has been called, that its session is still live. API
+// keys are looked up instead. ok is false when there is no usable token.
+func authenticate(r *http.Request, secret []byte) (identity, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return identity{}, false
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+
+	if strings.HasPrefix(tokenString, APIKeyPrefix) {
+		return authenticateKey(r.Context(), tokenString)
+	}
+
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (any, error) {
+		return secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !token.Valid {
+		return identity{}, false
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return identity{}, false
+	}
+
+	var id identity
+	id.userID, _ = claims["user_id"].(string)
+	id.email, _ = claims["email"].(string)
+	id.sessionID, _ = claims["sid"].(string)
+
+	if id.userID == "" {
+		return identity{}, false
+	}
+
+	if sessions != nil {
+		// tokens from before sessions existed have no sid and are refused
+		if id.sessionID == "" {
+			return identity{}, false
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			next.ServeHTTP(w, r)
-			return
+		active, err := sessions.SessionActive(r.Context(), id.userID, id.sessionID)
+		if err != nil {
+			log.Printf("session check failed: %v", err)
+			return identity{}, false
+		}
+		if !active {
+			return identity{}, false
 		}
+	}
 
-		userID, _ := claims["user_id"].(string)
-		email, _ := claims["email"].(string)
+	return id, true
+}
 
-		ctx := r.Context()
+func authenticateKey(ctx context.Context, key string) (identity, bool) {
+	if apiKeys == nil {
+		return identity{}, false
+	}
+
+	owner, err := apiKeys.CheckAPIKey(ctx, key)
+	if err != nil {
+		return identity{}, false
+	}
+
+	return identity{
+		userID: owner.UserID,
+		email:  owner.Email,
+		apiKey: true,
+		scopes: owner.Scopes,
+	}, true
+}
 
-		// ✅ Attach user identity if present
-		if userID != "" {
-			ctx = context.WithValue(ctx, userIDKey, userID)
-		}
-		if email != "" {
-			ctx = context.WithValue(ctx, emailKey, email)
+// allows reports whether the caller may use a route needing required.
+// Logins may use any route.
+func (id identity) allows(required []Scope) bool {
+	if !id.apiKey {
+		return true
+	}
+	if len(required) == 0 {
+		return false
+	}
+
+	for _, want := range required {
+		if !slices.Contains(id.scopes, want) {
+			return false
 		}
+	}
+	return true
+}
 
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+func (id identity) attach(ctx context.Context) context.Context {
+	ctx = context.WithValue(ctx, userIDKey, id.userID)
+	if id.email != "" {
+		ctx = context.WithValue(ctx, emailKey, id.email)
+	}
+	if id.sessionID != "" {
+		ctx = context.WithValue(ctx, sessionIDKey, id.sessionID)
+	}
+	return ctx
 }
 
 
@@ -126,3 +268,9 @@ func GetEmail(ctx context.Context) (string, bool) {
 	email, ok := ctx.Value(emailKey).(string)
 	return email, ok
 }
+
+// GetSessionID returns the login session of the caller's access token.
+func GetSessionID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(sessionIDKey).(string)
+	return id, ok
+}
@@ -0,0 +1,127 @@
+package notes
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "both empty",
+			a:    "",
+			b:    "",
+			want: "",
+		},
+		{
+			name: "identical",
+			a:    "one\ntwo\nthree\n",
+			b:    "one\ntwo\nthree\n",
+			want: "",
+		},
+		{
+			name: "from empty",
+			a:    "",
+			b:    "one\ntwo\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -0,0 +1,2 @@\n" +
+				"+one\n" +
+				"+two\n",
+		},
+		{
+			name: "to empty",
+			a:    "one\ntwo\n",
+			b:    "",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,2 +0,0 @@\n" +
+				"-one\n" +
+				"-two\n",
+		},
+		{
+			name: "pure addition",
+			a:    "1\n2\n3\n",
+			b:    "1\n2\nnew\n3\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,3 +1,4 @@\n" +
+				" 1\n" +
+				" 2\n" +
+				"+new\n" +
+				" 3\n",
+		},
+		{
+			name: "pure deletion",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:    "1\n2\n3\n4\n6\n7\n8\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -2,7 +2,6 @@\n" +
+				" 2\n" +
+				" 3\n" +
+				" 4\n" +
+				"-5\n" +
+				" 6\n" +
+				" 7\n" +
+				" 8\n",
+		},
+		{
+			name: "single line replaced",
+			a:    "only\n",
+			b:    "changed\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -1 +1 @@\n" +
+				"-only\n" +
+				"+changed\n",
+		},
+		{
+			name: "distant changes make separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,4 +1,4 @@\n" +
+				"-1\n" +
+				"+one\n" +
+				" 2\n" +
+				" 3\n" +
+				" 4\n" +
+				"@@ -7,4 +7,4 @@\n" +
+				" 7\n" +
+				" 8\n" +
+				" 9\n" +
+				"-10\n" +
+				"+ten\n",
+		},
+		{
+			name: "missing trailing newline is not a change",
+			a:    "one\ntwo",
+			b:    "one\ntwo\n",
+			want: "",
+		},
+		{
+			name: "trailing newline with added line",
+			a:    "one",
+			b:    "one\ntwo\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -1 +1,2 @@\n" +
+				" one\n" +
+				"+two\n",
+		},
+		{
+			name: "blank lines count as lines",
+			a:    "one\n\n",
+			b:    "one\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,2 +1 @@\n" +
+				" one\n" +
+				"-\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unifiedDiff("a", "b", tt.a, tt.b)
+			if got != tt.want {
+				t.Errorf("unifiedDiff(%q, %q) =\n%s\nwant\n%s", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,11 +2,19 @@ package notes
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/PRASHANTSWAROOP001/notes-app/internal/middleware"
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/ratelimit"
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/workspace"
 )
 
 // NoteHandler is the top-level HTTP handler for the Notes feature.
@@ -54,9 +62,12 @@ func (h *NoteHandler) CreateNote(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Title   string `json:"title"`
-		Content string `json:"content"`
-		Public  bool   `json:"public"`
+		Title       string   `json:"title"`
+		Content     string   `json:"content"`
+		Public      bool     `json:"public"`
+		Tags        []string `json:"tags"`
+		FolderID    *string  `json:"folder_id"`
+		WorkspaceID *string  `json:"workspace_id"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -65,19 +76,23 @@ func (h *NoteHandler) CreateNote(w http.ResponseWriter, r *http.Request) {
 	}
 
 	note := &Note{
-		AuthorID: userId,
-		Title:    req.Title,
-		Content:  req.Content,
-		Public:   req.Public,
+		AuthorID:    userId,
+		Title:       req.Title,
+		Content:     req.Content,
+		Public:      req.Public,
+		Tags:        req.Tags,
+		FolderID:    req.FolderID,
+		WorkspaceID: req.WorkspaceID,
 	}
 
 	createdNote, err := h.service.CreateNote(r.Context(), note)
 
 	if err != nil {
-		http.Error(w, "could not create the note", http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("could not create the note: %v", err), errorStatus(err))
 		return
 	}
 
+	setETag(w, createdNote.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(createdNote)
 
@@ -96,10 +111,22 @@ func (h *NoteHandler) GetUserNotes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	notesData, err := h.service.GetUserNotes(r.Context(), userId)
+	filter, err := parseNoteFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := parsePageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	notesData, err := h.service.GetUserNotes(r.Context(), userId, filter, page)
 
 	if err != nil {
-		http.Error(w, "error while fetching users data", http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("error while fetching users data: %v", err), errorStatus(err))
 		return
 	}
 
@@ -109,6 +136,40 @@ func (h *NoteHandler) GetUserNotes(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// GetSharedWithMe lists the notes other users shared with the caller's
+// email. It takes the same sort, limit and cursor params as GetUserNotes.
+func (h *NoteHandler) GetSharedWithMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	email, _ := middleware.GetEmail(r.Context())
+
+	page, err := parsePageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	notesData, err := h.service.GetSharedWithMe(r.Context(), email, page)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while fetching shared notes: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notesData)
+}
+
 func (h *NoteHandler) GetUserNoteById(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodGet {
@@ -137,6 +198,11 @@ func (h *NoteHandler) GetUserNoteById(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !h.applyFormat(w, r, note) {
+		return
+	}
+
+	setETag(w, note.Version)
 	w.Header().Set("Content-Type", "application/json")
 
 	json.NewEncoder(w).Encode(note)
@@ -174,22 +240,29 @@ func (h *NoteHandler) DeleteNote(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
 		"status":  "success",
-		"message": "note deleted successfully",
+		"message": "note moved to trash",
 	})
 
 }
 
 func (h *NoteHandler) UpdateNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPatch {
+		h.PatchNote(w, r)
+		return
+	}
+
 	if r.Method != http.MethodPut {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	// tags is optional: omit it to keep the current tags, send [] to clear them
 	var req struct {
-		ID      string `json:"id"`
-		Title   string `json:"title"`
-		Content string `json:"content"`
-		Public  bool   `json:"public"`
+		ID      string   `json:"id"`
+		Title   string   `json:"title"`
+		Content string   `json:"content"`
+		Public  bool     `json:"public"`
+		Tags    []string `json:"tags"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -204,23 +277,133 @@ func (h *NoteHandler) UpdateNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// If-Match is optional; without it the update is unconditional
+	version, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	email, _ := middleware.GetEmail(r.Context())
+
 	note := &Note{
-		ID:       req.ID,
-		Title:    req.Title,
-		Content:  req.Content,
-		Public:   req.Public,
-		AuthorID: userId,
+		ID:      req.ID,
+		Title:   req.Title,
+		Content: req.Content,
+		Public:  req.Public,
+		Tags:    req.Tags,
+		Version: version,
+	}
+
+	noteSummary, err := h.service.UpdateNote(r.Context(), Caller{UserID: userId, Email: email}, note)
+
+	var conflict *VersionConflictError
+	if errors.As(err, &conflict) {
+		writeVersionConflict(w, conflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error %v", err), errorStatus(err))
+		return
+	}
+
+	setETag(w, noteSummary.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(noteSummary)
+
+}
+
+// PatchNote applies a JSON Merge Patch to the note named by ?id=. Only the
+// fields present in the body change; If-Match is honoured like on PUT.
+func (h *NoteHandler) PatchNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	noteID := r.URL.Query().Get("id")
+
+	if noteID == "" {
+		http.Error(w, "missing note id param", http.StatusBadRequest)
+		return
+	}
+
+	patch, err := decodeNotePatch(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	patch.Version, err = parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	noteSummary, err := h.service.UpdateNote(r.Context(), note)
+	email, _ := middleware.GetEmail(r.Context())
+
+	noteSummary, err := h.service.PatchNote(r.Context(), noteID, Caller{UserID: userId, Email: email}, patch)
+
+	var conflict *VersionConflictError
+	if errors.As(err, &conflict) {
+		writeVersionConflict(w, conflict)
+		return
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("error %v", err), errorStatus(err))
 		return
 	}
 
+	setETag(w, noteSummary.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(noteSummary)
+}
 
+// decodeNotePatch reads a merge patch body. Absent keys stay nil; null is
+// only meaningful for tags (clear them), since a note always has a title,
+// content and visibility.
+func decodeNotePatch(body io.Reader) (NotePatch, error) {
+	var patch NotePatch
+
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		return patch, fmt.Errorf("invalid request body")
+	}
+
+	for key, value := range raw {
+		isNull := string(value) == "null"
+
+		switch key {
+		case "title":
+			if isNull || json.Unmarshal(value, &patch.Title) != nil {
+				return patch, fmt.Errorf("title must be a string")
+			}
+		case "content":
+			if isNull || json.Unmarshal(value, &patch.Content) != nil {
+				return patch, fmt.Errorf("content must be a string")
+			}
+		case "public":
+			if isNull || json.Unmarshal(value, &patch.Public) != nil {
+				return patch, fmt.Errorf("public must be a boolean")
+			}
+		case "tags":
+			patch.Tags = []string{}
+			if !isNull && json.Unmarshal(value, &patch.Tags) != nil {
+				return patch, fmt.Errorf("tags must be an array of strings")
+			}
+		default:
+			return patch, fmt.Errorf("field %q cannot be patched", key)
+		}
+	}
+
+	return patch, nil
 }
 
 func (h *NoteHandler) ShareWithEmail(w http.ResponseWriter, r *http.Request) {
@@ -229,9 +412,11 @@ func (h *NoteHandler) ShareWithEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// role defaults to viewer when omitted
 	var req struct {
 		ID    string `json:"id"`
 		Email string `json:"email"`
+		Role  Role   `json:"role"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -246,10 +431,10 @@ func (h *NoteHandler) ShareWithEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.service.ShareNoteViaEmail(r.Context(), req.ID, userId, req.Email)
+	err := h.service.ShareNoteViaEmail(r.Context(), req.ID, userId, req.Email, req.Role)
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error while adding email %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("error while adding email %v", err), errorStatus(err))
 		return
 	}
 
@@ -287,7 +472,7 @@ func (h *NoteHandler) RemoveEmailShare(w http.ResponseWriter, r *http.Request) {
 	err := h.service.RevokeEmailAccess(r.Context(), noteid, userId, email)
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error while adding email %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("error while adding email %v", err), errorStatus(err))
 		return
 	}
 
@@ -307,10 +492,11 @@ func (h *NoteHandler) GetPublicAccess(w http.ResponseWriter, r *http.Request) {
 	}
 
 	slug := r.URL.Query().Get("q")
+	token := r.URL.Query().Get("token")
 
 	userID, _ := middleware.GetUserID(r.Context())
 	userEmail, _ := middleware.GetEmail(r.Context())
-    log.Print(slug)
+	log.Print(slug)
 	log.Print(userID)
 	log.Print(userEmail)
 
@@ -319,19 +505,1439 @@ func (h *NoteHandler) GetPublicAccess(w http.ResponseWriter, r *http.Request) {
 		err  error
 	)
 
-	if userID == "" {
-		note, err = h.service.GetPublicNote(r.Context(), slug, nil, nil)
+	// a share link token wins over the slug and needs no login
+	if token != "" {
+		note, err = h.service.GetNoteByShareLink(r.Context(), token)
+	} else if userID == "" {
+		note, err = h.service.GetPublicNote(r.Context(), slug, nil, nil, readNoteUnlock(r))
 	} else {
-		
-		note, err = h.service.GetPublicNote(r.Context(), slug, &userID, &userEmail)
+
+		note, err = h.service.GetPublicNote(r.Context(), slug, &userID, &userEmail, readNoteUnlock(r))
 	}
 
-	if err != nil {
+	var moved *SlugMovedError
+
+	switch {
+	case errors.As(err, &moved):
+		query := r.URL.Query()
+		query.Set("q", moved.Slug)
+		http.Redirect(w, r, r.URL.Path+"?"+query.Encode(), http.StatusMovedPermanently)
+		return
+	case errors.Is(err, ErrShareLinkGone), errors.Is(err, ErrNoteLocked),
+		errors.Is(err, ErrWrongPassword), errors.Is(err, ErrTooManyAttempts):
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	case err != nil:
 		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
 
+	if note.UnlockToken != "" {
+		setUnlockCookie(w, r, note)
+	}
+
+	// owners looking at their own note are not readers
+	if note.Role != RoleOwner {
+		h.service.RecordView(NoteView{
+			NoteID:        note.ID,
+			Authenticated: userID != "",
+			ViewerKey:     viewerKey(userID, ratelimit.ClientIP(r), r.UserAgent()),
+			Referrer:      referrerHost(r.Referer()),
+			AgentClass:    classifyAgent(r.UserAgent()),
+		})
+	}
+
+	if !h.applyFormat(w, r, note) {
+		return
+	}
+
 	// ✅ Now you can return the note
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(note)
 }
+
+// unlockCookiePrefix names the cookies holding unlock tokens; each note gets
+// its own, suffixed with the note id.
+const unlockCookiePrefix = "note_unlock_"
+
+// readNoteUnlock collects what the reader sent to open a password-protected
+// note: the passphrase in X-Note-Password, and unlock tokens from the
+// X-Note-Unlock header or earlier unlock cookies.
+func readNoteUnlock(r *http.Request) NoteUnlock {
+	unlock := NoteUnlock{
+		Password: r.Header.Get("X-Note-Password"),
+		Client:   ratelimit.ClientIP(r),
+	}
+
+	if token := r.Header.Get("X-Note-Unlock"); token != "" {
+		unlock.Tokens = append(unlock.Tokens, token)
+	}
+
+	for _, c := range r.Cookies() {
+		if strings.HasPrefix(c.Name, unlockCookiePrefix) {
+			unlock.Tokens = append(unlock.Tokens, c.Value)
+		}
+	}
+
+	return unlock
+}
+
+// setUnlockCookie stores a fresh unlock token so the browser sends it with
+// later requests for the note and its attachments.
+func setUnlockCookie(w http.ResponseWriter, r *http.Request, note *Note) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookiePrefix + note.ID,
+		Value:    note.UnlockToken,
+		Path:     "/notes",
+		MaxAge:   int(unlockTokenTTL / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var throttled *TooManyAttemptsError
+	if errors.As(err, &throttled) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(throttled.RetryAfter.Seconds())+1))
+	}
+}
+
+// applyFormat honours the ?format= query param: "html" adds the rendered
+// content to the note, "markdown" or nothing leaves it as stored. It writes
+// the error response itself and returns false if the request should stop.
+func (h *NoteHandler) applyFormat(w http.ResponseWriter, r *http.Request, note *Note) bool {
+	switch r.URL.Query().Get("format") {
+	case "", "markdown":
+		return true
+	case "html":
+		html, err := h.service.RenderHTML(note)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error while rendering note: %v", err), http.StatusInternalServerError)
+			return false
+		}
+		note.ContentHTML = html
+		return true
+	default:
+		http.Error(w, "format must be markdown or html", http.StatusBadRequest)
+		return false
+	}
+}
+
+// setETag exposes a note version as a strong ETag, e.g. "7".
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(version)))
+}
+
+// parseIfMatch reads the version out of an If-Match header. An empty header
+// or "*" means no precondition and yields 0.
+func parseIfMatch(header string) (int, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	// only one tag is meaningful for a single note; weak tags compare the same
+	tag := strings.TrimPrefix(header, "W/")
+	unquoted, err := strconv.Unquote(tag)
+	if err != nil {
+		return 0, fmt.Errorf("invalid If-Match header")
+	}
+
+	version, err := strconv.Atoi(unquoted)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid If-Match header")
+	}
+
+	return version, nil
+}
+
+// writeVersionConflict answers 412 with the version the server holds, so the
+// client can refetch and merge.
+func writeVersionConflict(w http.ResponseWriter, conflict *VersionConflictError) {
+	setETag(w, conflict.CurrentVersion)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusPreconditionFailed)
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":          "error",
+		"message":         ErrVersionMismatch.Error(),
+		"current_version": conflict.CurrentVersion,
+	})
+}
+
+// errorStatus maps the sentinel errors of this package to an HTTP status,
+// falling back to 500 for anything unexpected.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNoteNotFound), errors.Is(err, ErrRevisionNotFound),
+		errors.Is(err, ErrAttachmentNotFound), errors.Is(err, ErrShareLinkNotFound),
+		errors.Is(err, ErrShareNotFound), errors.Is(err, workspace.ErrWorkspaceNotFound),
+		errors.Is(err, ErrTagNotFound), errors.Is(err, ErrFolderNotFound),
+		errors.Is(err, ErrTransferNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrFolderCycle), errors.Is(err, ErrShareExists),
+		errors.Is(err, ErrTransferPending), errors.Is(err, ErrSlugTaken):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidTag), errors.Is(err, ErrInvalidCursor), errors.Is(err, ErrInvalidStatsRange),
+		errors.Is(err, ErrInvalidSort), errors.Is(err, ErrInvalidRole),
+		errors.Is(err, ErrWorkspaceFolder), errors.Is(err, ErrTransferRecipient),
+		errors.Is(err, ErrInvalidSlug), errors.Is(err, ErrEmptyTitle),
+		errors.Is(err, ErrEmptyContent), errors.Is(err, ErrEmptyPatch):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrVisibilityForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrShareLinkGone):
+		return http.StatusGone
+	case errors.Is(err, ErrNoteLocked), errors.Is(err, ErrWrongPassword):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrTooManyAttempts):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrWeakPassword):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrAttachmentTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrAttachmentType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrTagExists):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *NoteHandler) ListRevisions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	noteID := r.URL.Query().Get("id")
+
+	if noteID == "" {
+		http.Error(w, "missing note id param", http.StatusBadRequest)
+		return
+	}
+
+	revisions, err := h.service.ListRevisions(r.Context(), noteID, userId)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while listing revisions: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
+}
+
+func (h *NoteHandler) GetRevision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	noteID := r.URL.Query().Get("id")
+	revision, err := strconv.Atoi(r.URL.Query().Get("rev"))
+
+	if noteID == "" || err != nil {
+		http.Error(w, "missing or invalid id/rev params", http.StatusBadRequest)
+		return
+	}
+
+	rv, err := h.service.GetRevision(r.Context(), noteID, userId, revision)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while getting revision: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rv)
+}
+
+func (h *NoteHandler) DiffRevisions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	noteID := r.URL.Query().Get("id")
+	from, errFrom := strconv.Atoi(r.URL.Query().Get("from"))
+	to, errTo := strconv.Atoi(r.URL.Query().Get("to"))
+
+	if noteID == "" || errFrom != nil || errTo != nil {
+		http.Error(w, "missing or invalid id/from/to params", http.StatusBadRequest)
+		return
+	}
+
+	diff, err := h.service.DiffRevisions(r.Context(), noteID, userId, from, to)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while diffing revisions: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
+
+func (h *NoteHandler) RestoreRevision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ID       string `json:"id"`
+		Revision int    `json:"revision"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	noteSummary, err := h.service.RestoreRevision(r.Context(), req.ID, userId, req.Revision)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while restoring revision: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(noteSummary)
+}
+
+func (h *NoteHandler) SearchNotes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	email, _ := middleware.GetEmail(r.Context())
+
+	q := r.URL.Query().Get("q")
+
+	if q == "" {
+		http.Error(w, "missing q param", http.StatusBadRequest)
+		return
+	}
+
+	// an absent or malformed limit falls back to the service default
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	results, err := h.service.Search(r.Context(), userId, email, q, limit)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while searching notes: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+func (h *NoteHandler) ListTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tags, err := h.service.ListTags(r.Context(), userId)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while listing tags: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+func (h *NoteHandler) RenameTag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.RenameTag(r.Context(), userId, req.From, req.To); err != nil {
+		http.Error(w, fmt.Sprintf("error while renaming tag: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "tag renamed successfully",
+	})
+}
+
+func (h *NoteHandler) MergeTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Sources []string `json:"sources"`
+		Target  string   `json:"target"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.MergeTags(r.Context(), userId, req.Sources, req.Target); err != nil {
+		http.Error(w, fmt.Sprintf("error while merging tags: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "tags merged successfully",
+	})
+}
+
+func (h *NoteHandler) CreateFolder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Name     string  `json:"name"`
+		ParentID *string `json:"parent_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	folder, err := h.service.CreateFolder(r.Context(), &Folder{
+		OwnerID:  userId,
+		ParentID: req.ParentID,
+		Name:     req.Name,
+	})
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while creating folder: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(folder)
+}
+
+func (h *NoteHandler) GetFolderTree(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tree, err := h.service.GetFolderTree(r.Context(), userId)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while listing folders: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tree)
+}
+
+func (h *NoteHandler) MoveFolder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// a null parent_id moves the folder to the top level
+	var req struct {
+		ID       string  `json:"id"`
+		ParentID *string `json:"parent_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.MoveFolder(r.Context(), userId, req.ID, req.ParentID); err != nil {
+		http.Error(w, fmt.Sprintf("error while moving folder: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "folder moved successfully",
+	})
+}
+
+func (h *NoteHandler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	folderID := r.URL.Query().Get("id")
+
+	if folderID == "" {
+		http.Error(w, "missing folder id", http.StatusBadRequest)
+		return
+	}
+
+	mode := FolderDeleteMode(r.URL.Query().Get("mode"))
+
+	if err := h.service.DeleteFolder(r.Context(), userId, folderID, mode); err != nil {
+		http.Error(w, fmt.Sprintf("error while deleting folder: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "folder deleted successfully",
+	})
+}
+
+func (h *NoteHandler) MoveNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// a null folder_id moves the note back to the top level
+	var req struct {
+		ID       string  `json:"id"`
+		FolderID *string `json:"folder_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.MoveNote(r.Context(), userId, req.ID, req.FolderID); err != nil {
+		http.Error(w, fmt.Sprintf("error while moving note: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "note moved successfully",
+	})
+}
+
+func (h *NoteHandler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	notesData, err := h.service.ListTrash(r.Context(), userId)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while listing trash: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notesData)
+}
+
+func (h *NoteHandler) RestoreNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.RestoreNote(r.Context(), req.ID, userId); err != nil {
+		http.Error(w, fmt.Sprintf("error while restoring note: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "note restored successfully",
+	})
+}
+
+func (h *NoteHandler) EmptyTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	purged, err := h.service.EmptyTrash(r.Context(), userId)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while emptying trash: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":  "success",
+		"message": "trash emptied successfully",
+		"deleted": purged,
+	})
+}
+
+// parseNoteFilter reads the listing filters from the query string:
+// tags (comma separated), match=all|any, folder, public=true|false and the
+// created_after / created_before / updated_after / updated_before bounds.
+func parseNoteFilter(r *http.Request) (NoteFilter, error) {
+	q := r.URL.Query()
+
+	filter := NoteFilter{
+		MatchAll:    q.Get("match") == "all",
+		FolderID:    q.Get("folder"),
+		WorkspaceID: q.Get("workspace"),
+	}
+
+	if tags := q.Get("tags"); tags != "" {
+		filter.Tags = strings.Split(tags, ",")
+	}
+
+	if v := q.Get("public"); v != "" {
+		public, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid public param")
+		}
+		filter.Public = &public
+	}
+
+	for param, dst := range map[string]**time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+		"updated_after":  &filter.UpdatedAfter,
+		"updated_before": &filter.UpdatedBefore,
+	} {
+		v := q.Get(param)
+		if v == "" {
+			continue
+		}
+		t, err := parseTimeParam(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s param", param)
+		}
+		*dst = &t
+	}
+
+	return filter, nil
+}
+
+// parsePageRequest reads sort=created|updated|title, order=asc|desc, limit
+// and cursor. Dates sort newest first and titles A-Z unless order is given.
+func parsePageRequest(r *http.Request) (PageRequest, error) {
+	q := r.URL.Query()
+
+	page := PageRequest{
+		Sort:   NoteSort(q.Get("sort")),
+		Cursor: q.Get("cursor"),
+	}
+
+	if page.Sort == "" {
+		page.Sort = SortCreated
+	}
+
+	switch q.Get("order") {
+	case "":
+		page.Desc = page.Sort != SortTitle
+	case "asc":
+		page.Desc = false
+	case "desc":
+		page.Desc = true
+	default:
+		return page, fmt.Errorf("order must be asc or desc")
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return page, fmt.Errorf("invalid limit param")
+		}
+		page.Limit = limit
+	}
+
+	return page, nil
+}
+
+// parseTimeParam accepts either a full RFC 3339 timestamp or a plain date,
+// which is taken as midnight UTC.
+func parseTimeParam(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, v)
+}
+
+func (h *NoteHandler) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	noteID := r.URL.Query().Get("id")
+
+	if noteID == "" {
+		http.Error(w, "missing note id param", http.StatusBadRequest)
+		return
+	}
+
+	// stream the file part straight to the service; it stops reading once
+	// the size limit is passed, so the body is never buffered whole
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "expected a multipart/form-data body", http.StatusBadRequest)
+		return
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			http.Error(w, "missing file field", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "invalid multipart body", http.StatusBadRequest)
+			return
+		}
+
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		attachment, err := h.service.AddAttachment(r.Context(), noteID, userId, part.FileName(), part)
+		part.Close()
+
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error while uploading attachment: %v", err), errorStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(attachment)
+		return
+	}
+}
+
+func (h *NoteHandler) ListAttachments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	noteID := r.URL.Query().Get("id")
+
+	if noteID == "" {
+		http.Error(w, "missing note id param", http.StatusBadRequest)
+		return
+	}
+
+	attachments, err := h.service.ListAttachments(r.Context(), noteID, userId)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while listing attachments: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attachments)
+}
+
+func (h *NoteHandler) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	attachmentID := r.URL.Query().Get("id")
+
+	if attachmentID == "" {
+		http.Error(w, "missing attachment id param", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := middleware.GetUserID(r.Context())
+	userEmail, _ := middleware.GetEmail(r.Context())
+
+	var (
+		attachment *Attachment
+		body       io.ReadCloser
+		err        error
+	)
+
+	if userID == "" {
+		attachment, body, err = h.service.OpenAttachment(r.Context(), attachmentID, nil, nil, readNoteUnlock(r))
+	} else {
+		attachment, body, err = h.service.OpenAttachment(r.Context(), attachmentID, &userID, &userEmail, readNoteUnlock(r))
+	}
+
+	if err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	defer body.Close()
+
+	// images and PDFs can be shown in the browser, everything else downloads
+	disposition := "attachment"
+	if strings.HasPrefix(attachment.ContentType, "image/") || attachment.ContentType == "application/pdf" {
+		disposition = "inline"
+	}
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	io.Copy(w, body)
+}
+
+func (h *NoteHandler) DeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	attachmentID := r.URL.Query().Get("id")
+
+	if attachmentID == "" {
+		http.Error(w, "missing attachment id param", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.DeleteAttachment(r.Context(), attachmentID, userId); err != nil {
+		http.Error(w, fmt.Sprintf("error while deleting attachment: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "attachment deleted successfully",
+	})
+}
+
+func (h *NoteHandler) CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// expires_at and max_views are both optional
+	var req struct {
+		ID        string     `json:"id"`
+		ExpiresAt *time.Time `json:"expires_at"`
+		MaxViews  *int       `json:"max_views"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	link, err := h.service.CreateShareLink(r.Context(), req.ID, userId, req.ExpiresAt, req.MaxViews)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while creating share link: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(link)
+}
+
+func (h *NoteHandler) ListShareLinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	noteID := r.URL.Query().Get("id")
+
+	if noteID == "" {
+		http.Error(w, "missing note id param", http.StatusBadRequest)
+		return
+	}
+
+	links, err := h.service.ListShareLinks(r.Context(), noteID, userId)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while listing share links: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
+
+func (h *NoteHandler) RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	linkID := r.URL.Query().Get("id")
+
+	if linkID == "" {
+		http.Error(w, "missing link id param", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.RevokeShareLink(r.Context(), linkID, userId); err != nil {
+		http.Error(w, fmt.Sprintf("error while revoking share link: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "share link revoked successfully",
+	})
+}
+
+// SetNotePassword sets the passphrase readers of a public note must enter.
+// An empty password removes it.
+func (h *NoteHandler) SetNotePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ID       string `json:"id"`
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.SetNotePassword(r.Context(), req.ID, userId, req.Password); err != nil {
+		http.Error(w, fmt.Sprintf("error while setting note password: %v", err), errorStatus(err))
+		return
+	}
+
+	message := "note password set successfully"
+	if req.Password == "" {
+		message = "note password removed successfully"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": message,
+	})
+}
+
+// SetNoteSlug gives a note a custom slug, or one derived from its current
+// title when slug is empty. Links using the old slug are redirected.
+func (h *NoteHandler) SetNoteSlug(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ID   string `json:"id"`
+		Slug string `json:"slug"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	slug, err := h.service.SetNoteSlug(r.Context(), req.ID, userId, req.Slug)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while setting slug: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "slug updated successfully",
+		"slug":    slug,
+	})
+}
+
+// Collaborators lists a note's shares on GET. On POST it applies a batch of
+// removals and additions in one transaction and returns the resulting list.
+func (h *NoteHandler) Collaborators(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var (
+		collaborators []*Collaborator
+		err           error
+	)
+
+	if r.Method == http.MethodGet {
+		noteID := r.URL.Query().Get("id")
+
+		if noteID == "" {
+			http.Error(w, "missing note id param", http.StatusBadRequest)
+			return
+		}
+
+		collaborators, err = h.service.ListCollaborators(r.Context(), noteID, userId)
+	} else {
+		// roles in add default to viewer when omitted
+		var req struct {
+			ID     string         `json:"id"`
+			Add    []Collaborator `json:"add"`
+			Remove []string       `json:"remove"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		collaborators, err = h.service.UpdateCollaborators(r.Context(), req.ID, userId, req.Add, req.Remove)
+	}
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while managing collaborators: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(collaborators)
+}
+
+func (h *NoteHandler) ListTransfers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	transfers, err := h.service.ListTransfers(r.Context(), userId)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while listing transfers: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transfers)
+}
+
+// ProposeTransfer offers the note id to the user with email, or every
+// personal note of the caller when all is set. Nothing changes hands until
+// the recipient accepts.
+func (h *NoteHandler) ProposeTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ID         string `json:"id"`
+		All        bool   `json:"all"`
+		Email      string `json:"email"`
+		KeepEditor bool   `json:"keep_editor"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.All == (req.ID != "") {
+		http.Error(w, "provide either id or all", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		transfers []*NoteTransfer
+		err       error
+	)
+
+	if req.All {
+		transfers, err = h.service.TransferAllNotes(r.Context(), userId, req.Email, req.KeepEditor)
+	} else {
+		var transfer *NoteTransfer
+		transfer, err = h.service.TransferNote(r.Context(), req.ID, userId, req.Email, req.KeepEditor)
+		transfers = []*NoteTransfer{transfer}
+	}
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while proposing transfer: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(transfers)
+}
+
+// AcceptTransfer takes ownership of the notes in a transfer (id) or a whole
+// bulk proposal (batch_id).
+func (h *NoteHandler) AcceptTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ID      string `json:"id"`
+		BatchID string `json:"batch_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	id, batch, ok := transferTarget(req.ID, req.BatchID)
+	if !ok {
+		http.Error(w, "provide either id or batch_id", http.StatusBadRequest)
+		return
+	}
+
+	moved, err := h.service.AcceptTransfer(r.Context(), userId, id, batch)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while accepting transfer: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":      "success",
+		"message":     "transfer accepted successfully",
+		"transferred": moved,
+	})
+}
+
+// CancelTransfer withdraws a proposal when called by the owner and declines
+// it when called by the recipient. It takes an id or batch_id param.
+func (h *NoteHandler) CancelTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, batch, ok := transferTarget(r.URL.Query().Get("id"), r.URL.Query().Get("batch_id"))
+	if !ok {
+		http.Error(w, "provide either id or batch_id param", http.StatusBadRequest)
+		return
+	}
+
+	cancelled, err := h.service.CancelTransfer(r.Context(), userId, id, batch)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while cancelling transfer: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":    "success",
+		"message":   "transfer cancelled successfully",
+		"cancelled": cancelled,
+	})
+}
+
+// transferTarget picks between a single transfer id and a batch id; exactly
+// one must be set.
+func transferTarget(id, batchID string) (string, bool, bool) {
+	switch {
+	case id != "" && batchID == "":
+		return id, false, true
+	case id == "" && batchID != "":
+		return batchID, true, true
+	default:
+		return "", false, false
+	}
+}
+
+// GetViewStats returns view counts for a note the caller owns. It takes the
+// note id and optional from, to (RFC 3339 or a plain date) and interval
+// (hour, day, week or month) params.
+func (h *NoteHandler) GetViewStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+
+	noteID := q.Get("id")
+
+	if noteID == "" {
+		http.Error(w, "missing note id param", http.StatusBadRequest)
+		return
+	}
+
+	var from, to time.Time
+	for name, dst := range map[string]*time.Time{"from": &from, "to": &to} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+
+		t, err := parseTimeParam(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s param", name), http.StatusBadRequest)
+			return
+		}
+		*dst = t
+	}
+
+	stats, err := h.service.GetViewStats(r.Context(), noteID, userId, from, to, q.Get("interval"))
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while fetching view stats: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,101 @@
+package notes
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// markdownCacheSize is how many rendered notes are kept in memory.
+const markdownCacheSize = 1024
+
+// markdownRenderer turns note content (CommonMark plus GFM tables, task lists,
+// strikethrough and autolinks) into HTML that is safe to embed in a page.
+//
+// Rendering is cached per note and keyed on updated_at, so an edit naturally
+// invalidates the old entry; stale entries age out of the LRU.
+type markdownRenderer struct {
+	md     goldmark.Markdown
+	policy *bluemonday.Policy
+
+	mu    sync.Mutex
+	cache map[string]*list.Element
+	order *list.List // front = most recently used
+}
+
+type markdownCacheEntry struct {
+	key  string
+	html string
+}
+
+func newMarkdownRenderer() *markdownRenderer {
+	// goldmark already drops raw HTML from the source; the sanitizer is the
+	// second line of defence against javascript: links and the like.
+	policy := bluemonday.UGCPolicy()
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+-]+$`)).OnElements("code")
+	policy.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	policy.AllowAttrs("checked", "disabled").OnElements("input")
+
+	return &markdownRenderer{
+		md:     goldmark.New(goldmark.WithExtensions(extension.GFM)),
+		policy: policy,
+		cache:  make(map[string]*list.Element),
+		order:  list.New(),
+	}
+}
+
+// Render returns the sanitized HTML for a note's content.
+func (m *markdownRenderer) Render(n *Note) (string, error) {
+	key := fmt.Sprintf("%s@%d", n.ID, n.UpdatedAt.UnixNano())
+
+	if html, ok := m.lookup(key); ok {
+		return html, nil
+	}
+
+	var buf bytes.Buffer
+	if err := m.md.Convert([]byte(n.Content), &buf); err != nil {
+		return "", fmt.Errorf("failed to render markdown: %w", err)
+	}
+
+	html := m.policy.Sanitize(buf.String())
+	m.store(key, html)
+
+	return html, nil
+}
+
+func (m *markdownRenderer) lookup(key string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.cache[key]
+	if !ok {
+		return "", false
+	}
+
+	m.order.MoveToFront(el)
+	return el.Value.(*markdownCacheEntry).html, true
+}
+
+func (m *markdownRenderer) store(key, html string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.cache[key]; ok {
+		m.order.MoveToFront(el)
+		return
+	}
+
+	m.cache[key] = m.order.PushFront(&markdownCacheEntry{key: key, html: html})
+
+	if m.order.Len() > markdownCacheSize {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.cache, oldest.Value.(*markdownCacheEntry).key)
+	}
+}
@@ -2,53 +2,589 @@ package notes
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"time"
 )
 
+var (
+	ErrNoteNotFound     = errors.New("note not found or access denied")
+	ErrRevisionNotFound = errors.New("revision not found")
+	ErrTagNotFound      = errors.New("tag not found")
+	ErrTagExists        = errors.New("a tag with that name already exists")
+	ErrInvalidTag       = errors.New("tag names must be 1-50 characters")
+	ErrFolderNotFound   = errors.New("folder not found")
+	ErrFolderCycle      = errors.New("a folder cannot be moved inside itself")
+	ErrInvalidCursor    = errors.New("invalid or mismatched cursor")
+	ErrInvalidSort      = errors.New("sort must be one of created, updated or title")
+	ErrWorkspaceFolder  = errors.New("workspace notes cannot be filed in personal folders")
+
+	ErrAttachmentNotFound = errors.New("attachment not found or access denied")
+	ErrAttachmentTooLarge = errors.New("attachment exceeds the size limit")
+	ErrAttachmentType     = errors.New("attachment type is not allowed")
+
+	ErrVersionMismatch = errors.New("note has been modified since it was read")
+	ErrInvalidRole     = errors.New("role must be viewer, commenter or editor")
+
+	ErrVisibilityForbidden = errors.New("only the owner can make a note public or private")
+
+	ErrEmptyTitle   = errors.New("title cannot be empty")
+	ErrEmptyContent = errors.New("content cannot be empty")
+	ErrEmptyPatch   = errors.New("patch must set at least one of title, content, public or tags")
+
+	ErrShareExists       = errors.New("note is already shared with that email")
+	ErrShareNotFound     = errors.New("note is not shared with that email")
+	ErrShareLinkNotFound = errors.New("share link not found")
+	ErrShareLinkGone     = errors.New("share link has expired or been revoked")
+
+	ErrNoteLocked      = errors.New("note is password protected")
+	ErrWrongPassword   = errors.New("wrong note password")
+	ErrTooManyAttempts = errors.New("too many password attempts")
+	ErrWeakPassword    = errors.New("note password must be at least 8 characters")
+
+	ErrTransferNotFound  = errors.New("transfer not found")
+	ErrTransferPending   = errors.New("a transfer is already pending for that note")
+	ErrTransferRecipient = errors.New("transfers must go to another registered user")
+
+	ErrInvalidSlug = errors.New("slugs must be 3-100 lowercase letters, digits and single hyphens")
+	ErrSlugTaken   = errors.New("slug is already in use")
+	ErrSlugMoved   = errors.New("note has moved to a new slug")
+
+	ErrInvalidStatsRange = errors.New("stats need from before to, an interval of hour, day, week or month and at most 1000 buckets")
+)
+
+// Caller is the signed-in user acting on a note. Shares are matched on Email,
+// which comes from the JWT.
+type Caller struct {
+	UserID string
+	Email  string
+}
+
+// VersionConflictError is returned when an update names a version that is no
+// longer current. It unwraps to ErrVersionMismatch.
+type VersionConflictError struct {
+	CurrentVersion int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%v (current version %d)", ErrVersionMismatch, e.CurrentVersion)
+}
+
+func (e *VersionConflictError) Unwrap() error {
+	return ErrVersionMismatch
+}
+
+// SlugMovedError is returned when a note is looked up by a slug it has since
+// given up. It unwraps to ErrSlugMoved.
+type SlugMovedError struct {
+	Slug string
+}
+
+func (e *SlugMovedError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrSlugMoved, e.Slug)
+}
+
+func (e *SlugMovedError) Unwrap() error {
+	return ErrSlugMoved
+}
+
+// TooManyAttemptsError is returned when a note password has been guessed
+// wrong too often. It unwraps to ErrTooManyAttempts.
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("%v, retry in %s", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e *TooManyAttemptsError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+// NoteUnlock is what a reader presents to open a password-protected note:
+// the passphrase, or unlock tokens from earlier successes. Client identifies
+// the reader for throttling, usually by IP address.
+type NoteUnlock struct {
+	Password string
+	Tokens   []string
+	Client   string
+}
+
+// Note is a single note. ContentHTML is only filled in when a caller asks
+// for the rendered form with format=html, and Role is the reader's role when
+// the note was fetched by slug.
+//
+// Version goes up by one on every content write. On an update a non-zero
+// Version is the version the client last saw, and the write is refused if
+// the note has moved on since.
 type Note struct {
-	ID         string    `json:"id"`
-	AuthorID   string    `json:"author_id"`
-	Title      string    `json:"title"`
-	Content    string    `json:"content"`
-	Public     bool      `json:"public"`
-	Slug       *string   `json:"slug,omitempty"`
-	SharedWith []string  `json:"shared_with,omitempty"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID          string    `json:"id"`
+	AuthorID    string    `json:"author_id"`
+	Title       string    `json:"title"`
+	Content     string    `json:"content"`
+	Public      bool      `json:"public"`
+	Slug        *string   `json:"slug,omitempty"`
+	SharedWith  []string  `json:"shared_with,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	FolderID    *string   `json:"folder_id,omitempty"`
+	WorkspaceID *string   `json:"workspace_id,omitempty"`
+	ContentHTML string    `json:"content_html,omitempty"`
+	Role        Role      `json:"role,omitempty"`
+	Version     int       `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	PasswordProtected bool   `json:"password_protected,omitempty"`
+	UnlockToken       string `json:"unlock_token,omitempty"`
+
+	// lockHash is the password hash the reader still has to get past,
+	// nil when the note is not locked for them.
+	lockHash *string
 }
 
 // this is for recieving values for admin and how much notes it created in list.
+// FolderPath runs from the top-level folder down to the note's folder.
 type NoteSummary struct {
+	ID          string        `json:"id"`
+	AuthorID    string        `json:"author_id"`
+	Title       string        `json:"title"`
+	Public      bool          `json:"public"`
+	Slug        *string       `json:"slug,omitempty"`
+	Tags        []string      `json:"tags,omitempty"`
+	FolderID    *string       `json:"folder_id,omitempty"`
+	FolderPath  []FolderCrumb `json:"folder_path,omitempty"`
+	WorkspaceID *string       `json:"workspace_id,omitempty"`
+	Version     int           `json:"version,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+	DeletedAt   *time.Time    `json:"deleted_at,omitempty"`
+}
+
+// ShareLink grants anonymous read access to one note to whoever holds its
+// token. Token is only set in the response that creates the link; after that
+// only its hash is kept. A nil ExpiresAt or MaxViews means no such limit.
+type ShareLink struct {
+	ID        string     `json:"id"`
+	NoteID    string     `json:"note_id"`
+	Token     string     `json:"token,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxViews  *int       `json:"max_views,omitempty"`
+	ViewCount int        `json:"view_count"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// NotePatch is a partial update following JSON Merge Patch (RFC 7396): a nil
+// field was absent from the patch and is left alone. Tags set to an empty
+// slice clears them. Version is the If-Match precondition, 0 for none.
+type NotePatch struct {
+	Title   *string
+	Content *string
+	Public  *bool
+	Tags    []string
+	Version int
+}
+
+// Folder is a notebook. Folders nest through ParentID; a nil parent means
+// a top-level folder. Children and NoteCount are filled in by tree listings.
+type Folder struct {
+	ID        string    `json:"id"`
+	OwnerID   string    `json:"owner_id"`
+	ParentID  *string   `json:"parent_id,omitempty"`
+	Name      string    `json:"name"`
+	NoteCount int       `json:"note_count"`
+	Children  []*Folder `json:"children,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FolderCrumb is one step of a breadcrumb trail.
+type FolderCrumb struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// FolderDeleteMode decides what happens to the contents of a deleted folder.
+type FolderDeleteMode string
+
+const (
+	// FolderDeleteCascade deletes every note and sub-folder inside the folder.
+	FolderDeleteCascade FolderDeleteMode = "cascade"
+	// FolderDeleteMoveUp hands the folder's notes and sub-folders to its parent.
+	FolderDeleteMoveUp FolderDeleteMode = "move-up"
+)
+
+// NoteFilter narrows a note listing. An empty filter returns everything.
+//
+// Tags are matched by name; with MatchAll a note must carry every tag,
+// otherwise any one of them is enough.
+//
+// FolderID limits the listing to notes directly inside one folder, Public to
+// public or private notes, and the time bounds are inclusive on After and
+// exclusive on Before.
+//
+// WorkspaceID lists that workspace's notes instead of the caller's personal
+// ones; the caller must be a member.
+type NoteFilter struct {
+	Tags        []string
+	MatchAll    bool
+	FolderID    string
+	WorkspaceID string
+	Public      *bool
+
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+}
+
+// NoteSort is the column a note listing is ordered by.
+type NoteSort string
+
+const (
+	SortCreated NoteSort = "created"
+	SortUpdated NoteSort = "updated"
+	SortTitle   NoteSort = "title"
+)
+
+// PageRequest asks for one page of a listing. Cursor is the NextCursor of
+// the previous page, or empty for the first page.
+type PageRequest struct {
+	Sort   NoteSort
+	Desc   bool
+	Limit  int
+	Cursor string
+}
+
+// NoteHeader is what share emails say about a note and its owner.
+type NoteHeader struct {
+	ID         string
+	Title      string
+	Slug       *string
+	OwnerName  string
+	OwnerEmail string
+}
+
+// Collaborator is one email a note is shared with.
+type Collaborator struct {
+	Email    string    `json:"email"`
+	Role     Role      `json:"role"`
+	SharedAt time.Time `json:"shared_at"`
+}
+
+// SharedNote is a note someone else shared with the caller, as listed in
+// their inbox. Role is the strongest role any of the shares grants.
+type SharedNote struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Slug      *string   `json:"slug,omitempty"`
+	Public    bool      `json:"public"`
+	OwnerID   string    `json:"owner_id"`
+	OwnerName string    `json:"owner_name"`
+	Role      Role      `json:"role"`
+	SharedAt  time.Time `json:"shared_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SharedNotePage is one page of the shared-with-me inbox.
+type SharedNotePage struct {
+	Notes      []*SharedNote `json:"notes"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// NotePage is one page of a note listing. NextCursor is empty on the last page.
+type NotePage struct {
+	Notes      []*NoteSummary `json:"notes"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// NoteTransfer is a pending offer to make another user the owner of a
+// personal note. Transfers proposed together share a BatchID, so a bulk
+// handover can be accepted or cancelled as one.
+type NoteTransfer struct {
+	ID         string    `json:"id"`
+	BatchID    string    `json:"batch_id"`
+	NoteID     string    `json:"note_id"`
+	NoteTitle  string    `json:"note_title"`
+	FromUserID string    `json:"from_user_id"`
+	FromEmail  string    `json:"from_email"`
+	ToUserID   string    `json:"to_user_id"`
+	ToEmail    string    `json:"to_email"`
+	KeepEditor bool      `json:"keep_editor"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TransferList is the caller's pending transfers, offered to them (Incoming)
+// and by them (Outgoing).
+type TransferList struct {
+	Incoming []*NoteTransfer `json:"incoming"`
+	Outgoing []*NoteTransfer `json:"outgoing"`
+}
+
+// AgentClass is the coarse kind of client a view came from.
+type AgentClass string
+
+const (
+	AgentDesktop AgentClass = "desktop"
+	AgentMobile  AgentClass = "mobile"
+	AgentBot     AgentClass = "bot"
+	AgentOther   AgentClass = "other"
+)
+
+// NoteView is one read of a note through the public note endpoint.
+// ViewerKey tells viewers apart for unique counts; the recorder replaces it
+// with a keyed hash before anything is stored, so stored keys do not name
+// anyone. Referrer is only the referring host, empty for direct visits.
+type NoteView struct {
+	NoteID        string
+	ViewedAt      time.Time
+	Authenticated bool
+	ViewerKey     string
+	Referrer      string
+	AgentClass    AgentClass
+}
+
+// ViewStats sums up the views of a note between From and To, in total and
+// per Interval-long bucket starting at each bucket's Start.
+type ViewStats struct {
+	NoteID        string       `json:"note_id"`
+	From          time.Time    `json:"from"`
+	To            time.Time    `json:"to"`
+	Interval      string       `json:"interval"`
+	Views         int64        `json:"views"`
+	UniqueViewers int64        `json:"unique_viewers"`
+	Authenticated int64        `json:"authenticated"`
+	Anonymous     int64        `json:"anonymous"`
+	Buckets       []ViewBucket `json:"buckets"`
+	Referrers     []ViewCount  `json:"referrers"`
+	Agents        []ViewCount  `json:"agents"`
+}
+
+// ViewBucket is the views in one interval of ViewStats.
+type ViewBucket struct {
+	Start         time.Time `json:"start"`
+	Views         int64     `json:"views"`
+	UniqueViewers int64     `json:"unique_viewers"`
+}
+
+// ViewCount is the number of views sharing a referrer or agent class.
+type ViewCount struct {
+	Name  string `json:"name"`
+	Views int64  `json:"views"`
+}
+
+// TagCount is a tag together with the number of notes carrying it.
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Attachment is a file uploaded to a note. The bytes live in a BlobStore under
+// StorageKey; readers get the same access to them as to the note itself.
+type Attachment struct {
+	ID          string    `json:"id"`
+	NoteID      string    `json:"note_id"`
+	UploaderID  string    `json:"uploader_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	StorageKey  string    `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// lockHash is as for Note, for the attachment's note.
+	lockHash *string
+}
+
+// AttachmentLimits bounds what can be uploaded. AllowedTypes are media types
+// without parameters, matched against the sniffed type of the upload rather
+// than whatever the client claims.
+type AttachmentLimits struct {
+	MaxSize      int64
+	AllowedTypes []string
+}
+
+// DefaultAttachmentLimits allows common images, PDFs, plain text and zip
+// archives up to 10 MiB.
+var DefaultAttachmentLimits = AttachmentLimits{
+	MaxSize: 10 << 20,
+	AllowedTypes: []string{
+		"image/png",
+		"image/jpeg",
+		"image/gif",
+		"image/webp",
+		"application/pdf",
+		"text/plain",
+		"application/zip",
+	},
+}
+
+// NoteRevision is an immutable snapshot of a note taken after every write.
+// Revision numbers start at 1 and increase by one per note.
+type NoteRevision struct {
+	ID        string    `json:"id"`
+	NoteID    string    `json:"note_id"`
+	Revision  int       `json:"revision"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	Public    bool      `json:"public"`
+	EditorID  string    `json:"editor_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RevisionSummary is the list view of a revision, without the content.
+type RevisionSummary struct {
+	Revision  int       `json:"revision"`
+	Title     string    `json:"title"`
+	Public    bool      `json:"public"`
+	EditorID  string    `json:"editor_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RevisionDiff is a line-level unified diff between two revisions of a note.
+type RevisionDiff struct {
+	NoteID    string `json:"note_id"`
+	From      int    `json:"from"`
+	To        int    `json:"to"`
+	FromTitle string `json:"from_title"`
+	ToTitle   string `json:"to_title"`
+	Diff      string `json:"diff"`
+}
+
+// SearchResult is a single full-text search hit. Snippet holds an excerpt of
+// the content with the matched terms wrapped in <mark> tags.
+type SearchResult struct {
 	ID        string    `json:"id"`
 	AuthorID  string    `json:"author_id"`
 	Title     string    `json:"title"`
 	Public    bool      `json:"public"`
 	Slug      *string   `json:"slug,omitempty"`
+	Owned     bool      `json:"owned"`
+	Rank      float32   `json:"rank"`
+	Snippet   string    `json:"snippet"`
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // this is to be used by repository like must be implemented function handling database.
 type NotesRepository interface {
 	CreateNote(ctx context.Context, n *Note) (*Note, error)
-	UpdateNote(ctx context.Context, n *Note) (*NoteSummary, error)
+	UpdateNote(ctx context.Context, n *Note, caller Caller) (*NoteSummary, error)
+	PatchNote(ctx context.Context, noteID string, caller Caller, patch NotePatch) (*NoteSummary, error)
 	DeleteNote(ctx context.Context, noteId, authorId string) error
 
 	GetNoteByID(ctx context.Context, noteID, authorID string) (*Note, error)
-	GetNotesByAuthor(ctx context.Context, authorID string) ([]*NoteSummary, error)
+	GetNotesByAuthor(ctx context.Context, authorID string, filter NoteFilter, page PageRequest) (*NotePage, error)
 
 	GetNoteBySlug(ctx context.Context, slug string, userId, emailId *string) (*Note, error)
-	AddEmailShare(ctx context.Context, noteId, ownerID, emailId string) error
+	GetSlugRedirect(ctx context.Context, oldSlug string, userID, emailID *string) (string, error)
+	SetNoteSlug(ctx context.Context, noteID, ownerID, slug string) (string, error)
+	AddEmailShare(ctx context.Context, noteId, ownerID, emailId string, role Role) error
 	RemoveEmailShare(ctx context.Context, noteId, ownerID, emailId string) error
+	ListCollaborators(ctx context.Context, noteID, ownerID string) ([]*Collaborator, error)
+	UpdateCollaborators(ctx context.Context, noteID, ownerID string, add []Collaborator, remove []string) ([]*Collaborator, error)
+	GetNoteHeader(ctx context.Context, noteID string) (*NoteHeader, error)
+	SetNotePassword(ctx context.Context, noteID, ownerID string, passwordHash *string) error
+	GetNotesSharedWith(ctx context.Context, email string, page PageRequest) (*SharedNotePage, error)
+
+	CreateShareLink(ctx context.Context, link *ShareLink, ownerID, tokenHash string) (*ShareLink, error)
+	ListShareLinks(ctx context.Context, noteID, ownerID string) ([]*ShareLink, error)
+	RevokeShareLink(ctx context.Context, linkID, ownerID string) error
+	GetNoteByShareLink(ctx context.Context, tokenHash string) (*Note, error)
+
+	RecordViews(ctx context.Context, views []NoteView) error
+	GetViewStats(ctx context.Context, noteID, ownerID string, from, to time.Time, interval string) (*ViewStats, error)
+
+	ProposeTransfers(ctx context.Context, ownerID, toEmail string, noteIDs []string, keepEditor bool) ([]*NoteTransfer, error)
+	ListTransfers(ctx context.Context, userID string) (*TransferList, error)
+	AcceptTransfers(ctx context.Context, userID, id string, batch bool) ([]string, error)
+	CancelTransfers(ctx context.Context, userID, id string, batch bool) (int64, error)
+
+	ListRevisions(ctx context.Context, noteID, authorID string) ([]*RevisionSummary, error)
+	GetRevision(ctx context.Context, noteID, authorID string, revision int) (*NoteRevision, error)
+	RestoreRevision(ctx context.Context, noteID, authorID string, revision int) (*NoteSummary, error)
+
+	Search(ctx context.Context, userID, email, query string, limit int) ([]*SearchResult, error)
+
+	ListTags(ctx context.Context, ownerID string) ([]*TagCount, error)
+	RenameTag(ctx context.Context, ownerID, from, to string) error
+	MergeTags(ctx context.Context, ownerID string, sources []string, target string) error
+
+	CreateFolder(ctx context.Context, f *Folder) (*Folder, error)
+	ListFolders(ctx context.Context, ownerID string) ([]*Folder, error)
+	MoveFolder(ctx context.Context, ownerID, folderID string, parentID *string) error
+	DeleteFolder(ctx context.Context, ownerID, folderID string, mode FolderDeleteMode) error
+	MoveNote(ctx context.Context, ownerID, noteID string, folderID *string) error
+
+	ListTrash(ctx context.Context, authorID string) ([]*NoteSummary, error)
+	RestoreNote(ctx context.Context, noteID, authorID string) error
+	EmptyTrash(ctx context.Context, authorID string) (int64, []string, error)
+	PurgeTrash(ctx context.Context, deletedBefore time.Time) (int64, []string, error)
+
+	CanEditAttachments(ctx context.Context, noteID, userID string) error
+	CreateAttachment(ctx context.Context, a *Attachment) (*Attachment, error)
+	ListAttachments(ctx context.Context, noteID, authorID string) ([]*Attachment, error)
+	GetAttachment(ctx context.Context, attachmentID string, userID, emailID *string) (*Attachment, error)
+	DeleteAttachment(ctx context.Context, attachmentID, authorID string) (*Attachment, error)
 }
 
 // this is to be implemented by services will be used via repos and handler.
 type NotesService interface {
 	CreateNote(ctx context.Context, note *Note) (*Note, error)
-	UpdateNote(ctx context.Context, note *Note) (*NoteSummary, error)
+	UpdateNote(ctx context.Context, caller Caller, note *Note) (*NoteSummary, error)
+	PatchNote(ctx context.Context, noteID string, caller Caller, patch NotePatch) (*NoteSummary, error)
 	DeleteNote(ctx context.Context, noteID, userID string) error
-	GetUserNotes(ctx context.Context, userID string) ([]*NoteSummary, error)
+	GetUserNotes(ctx context.Context, userID string, filter NoteFilter, page PageRequest) (*NotePage, error)
 	GetUserNote(ctx context.Context, noteID, userID string) (*Note, error)
-	GetPublicNote(ctx context.Context, slug string, userId, emailId *string) (*Note, error)
-	ShareNoteViaEmail(ctx context.Context, noteID, ownerID, email string) error
+	GetPublicNote(ctx context.Context, slug string, userId, emailId *string, unlock NoteUnlock) (*Note, error)
+	SetNoteSlug(ctx context.Context, noteID, ownerID, slug string) (string, error)
+	RenderHTML(note *Note) (string, error)
+	ShareNoteViaEmail(ctx context.Context, noteID, ownerID, email string, role Role) error
 	RevokeEmailAccess(ctx context.Context, noteID, ownerID, email string) error
+	ListCollaborators(ctx context.Context, noteID, ownerID string) ([]*Collaborator, error)
+	UpdateCollaborators(ctx context.Context, noteID, ownerID string, add []Collaborator, remove []string) ([]*Collaborator, error)
+	SetNotePassword(ctx context.Context, noteID, ownerID, password string) error
+	GetSharedWithMe(ctx context.Context, email string, page PageRequest) (*SharedNotePage, error)
+
+	CreateShareLink(ctx context.Context, noteID, ownerID string, expiresAt *time.Time, maxViews *int) (*ShareLink, error)
+	ListShareLinks(ctx context.Context, noteID, ownerID string) ([]*ShareLink, error)
+	RevokeShareLink(ctx context.Context, linkID, ownerID string) error
+	GetNoteByShareLink(ctx context.Context, token string) (*Note, error)
+
+	RecordView(view NoteView)
+	GetViewStats(ctx context.Context, noteID, ownerID string, from, to time.Time, interval string) (*ViewStats, error)
+
+	TransferNote(ctx context.Context, noteID, ownerID, toEmail string, keepEditor bool) (*NoteTransfer, error)
+	TransferAllNotes(ctx context.Context, ownerID, toEmail string, keepEditor bool) ([]*NoteTransfer, error)
+	ListTransfers(ctx context.Context, userID string) (*TransferList, error)
+	AcceptTransfer(ctx context.Context, userID, id string, batch bool) (int, error)
+	CancelTransfer(ctx context.Context, userID, id string, batch bool) (int64, error)
+
+	ListRevisions(ctx context.Context, noteID, userID string) ([]*RevisionSummary, error)
+	GetRevision(ctx context.Context, noteID, userID string, revision int) (*NoteRevision, error)
+	DiffRevisions(ctx context.Context, noteID, userID string, from, to int) (*RevisionDiff, error)
+	RestoreRevision(ctx context.Context, noteID, userID string, revision int) (*NoteSummary, error)
+
+	Search(ctx context.Context, userID, email, query string, limit int) ([]*SearchResult, error)
+
+	ListTags(ctx context.Context, ownerID string) ([]*TagCount, error)
+	RenameTag(ctx context.Context, ownerID, from, to string) error
+	MergeTags(ctx context.Context, ownerID string, sources []string, target string) error
+
+	CreateFolder(ctx context.Context, f *Folder) (*Folder, error)
+	GetFolderTree(ctx context.Context, ownerID string) ([]*Folder, error)
+	MoveFolder(ctx context.Context, ownerID, folderID string, parentID *string) error
+	DeleteFolder(ctx context.Context, ownerID, folderID string, mode FolderDeleteMode) error
+	MoveNote(ctx context.Context, ownerID, noteID string, folderID *string) error
+
+	ListTrash(ctx context.Context, userID string) ([]*NoteSummary, error)
+	RestoreNote(ctx context.Context, noteID, userID string) error
+	EmptyTrash(ctx context.Context, userID string) (int64, error)
+
+	AddAttachment(ctx context.Context, noteID, userID, filename string, body io.Reader) (*Attachment, error)
+	ListAttachments(ctx context.Context, noteID, userID string) ([]*Attachment, error)
+	OpenAttachment(ctx context.Context, attachmentID string, userID, emailID *string, unlock NoteUnlock) (*Attachment, io.ReadCloser, error)
+	DeleteAttachment(ctx context.Context, attachmentID, userID string) error
 }
@@ -0,0 +1,68 @@
+package notes
+
+import (
+	"context"
+	"log"
+	"net/url"
+
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/mail"
+)
+
+// notifyShares emails the people who just gained or lost access to a note.
+// Mail is best effort: the share has already been saved, so failures are
+// logged rather than returned.
+func (s *service) notifyShares(ctx context.Context, noteID string, added []Collaborator, removed []string) {
+	if s.mailer == nil || len(added)+len(removed) == 0 {
+		return
+	}
+
+	header, err := s.repo.GetNoteHeader(ctx, noteID)
+	if err != nil {
+		log.Printf("share mail for note %s skipped: %v", noteID, err)
+		return
+	}
+
+	link := s.appURL
+	if header.Slug != nil {
+		link = s.appURL + "/notes?q=" + url.QueryEscape(*header.Slug)
+	}
+
+	for _, c := range added {
+		mail.SendBestEffort(ctx, s.mailer, "share_invite", c.Email, mail.ShareInvite{
+			OwnerName: header.OwnerName,
+			NoteTitle: header.Title,
+			Role:      string(c.Role),
+			Link:      link,
+		})
+	}
+
+	for _, email := range removed {
+		mail.SendBestEffort(ctx, s.mailer, "share_revoked", email, mail.ShareRevoked{
+			OwnerName: header.OwnerName,
+			NoteTitle: header.Title,
+		})
+	}
+}
+
+// notifyTransfer tells the recipient of a new transfer proposal about it,
+// with one mail per proposal however many notes it covers.
+func (s *service) notifyTransfer(ctx context.Context, transfers []*NoteTransfer) {
+	if s.mailer == nil || len(transfers) == 0 {
+		return
+	}
+
+	first := transfers[0]
+
+	header, err := s.repo.GetNoteHeader(ctx, first.NoteID)
+	if err != nil {
+		log.Printf("transfer mail for batch %s skipped: %v", first.BatchID, err)
+		return
+	}
+
+	mail.SendBestEffort(ctx, s.mailer, "transfer_offer", first.ToEmail, mail.TransferOffer{
+		OwnerName: header.OwnerName,
+		NoteTitle: first.NoteTitle,
+		Count:     len(transfers),
+		Link:      s.appURL + "/notes/transfers",
+	})
+}
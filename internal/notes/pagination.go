@@ -0,0 +1,130 @@
+package notes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// sortColumns maps each sort key to the column it orders by. Every listing
+// aliases the notes table as n, and ties are broken by n.id.
+var sortColumns = map[NoteSort]string{
+	SortCreated: "n.created_at",
+	SortUpdated: "n.updated_at",
+	SortTitle:   "n.title",
+}
+
+// pageCursor is the position after the last row of a page. It carries the
+// sort it was issued for, so it cannot be replayed against another ordering.
+type pageCursor struct {
+	Sort  NoteSort `json:"s"`
+	Desc  bool     `json:"d"`
+	Value string   `json:"v"`
+	ID    string   `json:"id"`
+}
+
+func encodeCursor(c pageCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var c pageCursor
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// keyset returns the ORDER BY clause for page and, when the page continues
+// from a cursor, a condition selecting the rows after it. Cursor values are
+// appended to args and referenced by position.
+func keyset(page PageRequest, args []any) (cond string, outArgs []any, orderBy string, err error) {
+	col, ok := sortColumns[page.Sort]
+	if !ok {
+		return "", nil, "", fmt.Errorf("unknown sort %q", page.Sort)
+	}
+
+	dir, op := "ASC", ">"
+	if page.Desc {
+		dir, op = "DESC", "<"
+	}
+	orderBy = fmt.Sprintf("%s %s, n.id %s", col, dir, dir)
+
+	if page.Cursor == "" {
+		return "", args, orderBy, nil
+	}
+
+	c, err := decodeCursor(page.Cursor)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	if c.Sort != page.Sort || c.Desc != page.Desc {
+		return "", nil, "", ErrInvalidCursor
+	}
+
+	var value any = c.Value
+	if page.Sort != SortTitle {
+		t, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return "", nil, "", ErrInvalidCursor
+		}
+		value = t
+	}
+
+	args = append(args, value, c.ID)
+	cond = fmt.Sprintf("(%s, n.id) %s ($%d, $%d::uuid)", col, op, len(args)-1, len(args))
+
+	return cond, args, orderBy, nil
+}
+
+// cursorAfter builds the cursor pointing just past a row with the given
+// sort values.
+func cursorAfter(page PageRequest, id, title string, createdAt, updatedAt time.Time) string {
+	c := pageCursor{Sort: page.Sort, Desc: page.Desc, ID: id}
+
+	switch page.Sort {
+	case SortTitle:
+		c.Value = title
+	case SortUpdated:
+		c.Value = updatedAt.Format(time.RFC3339Nano)
+	default:
+		c.Value = createdAt.Format(time.RFC3339Nano)
+	}
+
+	return encodeCursor(c)
+}
+
+// normalizePage fills in the default sort, direction and page size, and
+// rejects sort keys that are not supported.
+func normalizePage(page PageRequest) (PageRequest, error) {
+	if page.Sort == "" {
+		page.Sort = SortCreated
+		page.Desc = true
+	}
+
+	if _, ok := sortColumns[page.Sort]; !ok {
+		return page, ErrInvalidSort
+	}
+
+	if page.Limit <= 0 {
+		page.Limit = defaultPageSize
+	}
+	page.Limit = min(page.Limit, maxPageSize)
+
+	return page, nil
+}
@@ -0,0 +1,57 @@
+package notes
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/storage"
+)
+
+// TrashPurger permanently deletes notes that have sat in the trash for longer
+// than the retention period, along with their attachment blobs. It talks to
+// the repository directly because it acts for every user at once, outside of
+// any request.
+type TrashPurger struct {
+	repo      NotesRepository
+	blobs     storage.BlobStore
+	retention time.Duration
+	interval  time.Duration
+}
+
+// NewTrashPurger returns a purger that, every interval, removes trashed notes
+// deleted more than retention ago.
+func NewTrashPurger(r NotesRepository, blobs storage.BlobStore, retention, interval time.Duration) *TrashPurger {
+	return &TrashPurger{repo: r, blobs: blobs, retention: retention, interval: interval}
+}
+
+// Run purges once straight away and then on every tick until ctx is done.
+// It is meant to be started in its own goroutine.
+func (p *TrashPurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.purge(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *TrashPurger) purge(ctx context.Context) {
+	purged, keys, err := p.repo.PurgeTrash(ctx, time.Now().Add(-p.retention))
+	if err != nil {
+		log.Printf("trash purge failed: %v", err)
+		return
+	}
+
+	deleteBlobs(ctx, p.blobs, keys)
+
+	if purged > 0 {
+		log.Printf("trash purge removed %d notes", purged)
+	}
+}
@@ -2,11 +2,25 @@ package notes
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/workspace"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// noteTagsColumn selects the sorted tag names of the note aliased as n.
+const noteTagsColumn = `ARRAY(
+		SELECT t.name FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+		WHERE nt.note_id = n.id ORDER BY t.name
+	)`
+
 // postgresNotesRepository is a private struct that implements the NotesRepository interface.
 // It holds a database connection pool and provides methods that operate on it.
 type postgresNotesRepository struct {
@@ -28,45 +42,178 @@ func NewPostgresNotesRepository(db *pgxpool.Pool) NotesRepository {
 
 func (r *postgresNotesRepository) CreateNote(ctx context.Context, n *Note) (*Note, error) {
 	query := `
-	INSERT INTO notes(author_id, title, content, public, slug)
-	VALUES ($1, $2, $3, $4, $5)
-	RETURNING id, created_at, updated_at
+	INSERT INTO notes(author_id, title, content, public, slug, folder_id, workspace_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, version, created_at, updated_at
 	`
 
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating note: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if n.FolderID != nil {
+		if err := checkFolderOwner(ctx, tx, n.AuthorID, *n.FolderID); err != nil {
+			return nil, err
+		}
+	}
+
+	// writing into a workspace takes a role that can edit its notes
+	if n.WorkspaceID != nil {
+		var canWrite bool
+		err := tx.QueryRow(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM workspace_members
+			WHERE workspace_id = $1 AND user_id = $2 AND role IN (`+sqlStringList(workspaceRoles(PermEdit))+`)
+		)`, *n.WorkspaceID, n.AuthorID).Scan(&canWrite)
+		if err != nil {
+			return nil, fmt.Errorf("error while creating note: %w", err)
+		}
+		if !canWrite {
+			return nil, workspace.ErrWorkspaceNotFound
+		}
+	}
+
 	// Scan returned fields back into struct
-	err := r.db.QueryRow(ctx, query,
+	err = tx.QueryRow(ctx, query,
 		n.AuthorID,
 		n.Title,
 		n.Content,
 		n.Public,
 		n.Slug,
-	).Scan(&n.ID, &n.CreatedAt, &n.UpdatedAt)
+		n.FolderID,
+		n.WorkspaceID,
+	).Scan(&n.ID, &n.Version, &n.CreatedAt, &n.UpdatedAt)
 
 	if err != nil {
 		fmt.Printf("error while creating note: %v", err)
 		return nil, fmt.Errorf("error while creating note: %w", err)
 	}
 
-	slug := slugifyWithID(n.Title, n.ID)
-	n.Slug = &slug
-
-	_, err = r.db.Exec(ctx, `UPDATE notes SET slug=$1 WHERE id=$2`, n.Slug, n.ID)
+	// the short form is unique in practice; fall back to the full id when a
+	// live or retired slug already has it
+	err = tx.QueryRow(ctx, `
+	UPDATE notes SET slug = CASE
+		WHEN EXISTS (SELECT 1 FROM notes WHERE slug = $1)
+		  OR EXISTS (SELECT 1 FROM note_slug_history WHERE slug = $1) THEN $2
+		ELSE $1
+	END
+	WHERE id = $3
+	RETURNING slug
+	`, slugifyWithID(n.Title, n.ID), slugify(n.Title)+"-"+n.ID, n.ID).Scan(&n.Slug)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := snapshotRevision(ctx, tx, n.ID, n.AuthorID); err != nil {
+		return nil, err
+	}
+
+	if n.Tags != nil {
+		if err := setNoteTags(ctx, tx, n.ID, n.AuthorID, n.Tags); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error while creating note: %w", err)
+	}
+
 	return n, nil
 }
 
-func (r *postgresNotesRepository) GetNotesByAuthor(ctx context.Context, authorID string) ([]*NoteSummary, error) {
+func (r *postgresNotesRepository) GetNotesByAuthor(ctx context.Context, authorID string, filter NoteFilter, page PageRequest) (*NotePage, error) {
+	conditions := []string{"n.deleted_at IS NULL"}
+	args := []any{authorID}
+
+	// a workspace listing shows every note the member can read; otherwise
+	// only the caller's personal notes are listed
+	if filter.WorkspaceID != "" {
+		args = append(args, filter.WorkspaceID)
+		conditions = append(conditions,
+			fmt.Sprintf("n.workspace_id = $%d", len(args)),
+			memberCondition(1, 0),
+		)
+	} else {
+		conditions = append(conditions, "n.author_id = $1", "n.workspace_id IS NULL")
+	}
+
+	if filter.FolderID != "" {
+		args = append(args, filter.FolderID)
+		conditions = append(conditions, fmt.Sprintf("n.folder_id = $%d", len(args)))
+	}
+
+	if filter.Public != nil {
+		args = append(args, *filter.Public)
+		conditions = append(conditions, fmt.Sprintf("n.public = $%d", len(args)))
+	}
+
+	for _, bound := range []struct {
+		value *time.Time
+		expr  string
+	}{
+		{filter.CreatedAfter, "n.created_at >= $%d"},
+		{filter.CreatedBefore, "n.created_at < $%d"},
+		{filter.UpdatedAfter, "n.updated_at >= $%d"},
+		{filter.UpdatedBefore, "n.updated_at < $%d"},
+	} {
+		if bound.value != nil {
+			args = append(args, *bound.value)
+			conditions = append(conditions, fmt.Sprintf(bound.expr, len(args)))
+		}
+	}
+
+	if len(filter.Tags) > 0 {
+		args = append(args, filter.Tags)
+		tagsParam := len(args)
+
+		if filter.MatchAll {
+			args = append(args, len(filter.Tags))
+			conditions = append(conditions, fmt.Sprintf(`(
+				SELECT COUNT(*) FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+				WHERE nt.note_id = n.id AND t.name = ANY($%d)
+			) = $%d`, tagsParam, len(args)))
+		} else {
+			conditions = append(conditions, fmt.Sprintf(`EXISTS (
+				SELECT 1 FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+				WHERE nt.note_id = n.id AND t.name = ANY($%d)
+			)`, tagsParam))
+		}
+	}
+
+	cursorCond, args, orderBy, err := keyset(page, args)
+	if err != nil {
+		return nil, err
+	}
+	if cursorCond != "" {
+		conditions = append(conditions, cursorCond)
+	}
+
+	// one extra row tells us whether another page follows
+	args = append(args, page.Limit+1)
+
+	// folder_paths resolves every folder of the author to the ids and names
+	// of its ancestors, so each row can carry its breadcrumb trail.
 	query := `
-	SELECT id, title, author_id, public, slug, created_at
-	FROM notes
-	WHERE author_id = $1
-	ORDER BY created_at DESC
-	`
+	WITH RECURSIVE folder_paths AS (
+		SELECT f.id, ARRAY[f.id::text] AS ids, ARRAY[f.name] AS names
+		FROM folders f
+		WHERE f.owner_id = $1 AND f.parent_id IS NULL
+		UNION ALL
+		SELECT f.id, fp.ids || f.id::text, fp.names || f.name
+		FROM folders f
+		JOIN folder_paths fp ON f.parent_id = fp.id
+	)
+	SELECT n.id, n.title, n.author_id, n.public, n.slug, n.created_at, n.updated_at,
+	       ` + noteTagsColumn + `, n.folder_id, n.workspace_id, fp.ids, fp.names
+	FROM notes n
+	LEFT JOIN folder_paths fp ON fp.id = n.folder_id
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY ` + orderBy + `
+	LIMIT $` + strconv.Itoa(len(args))
 
-	rows, err := r.db.Query(ctx, query, authorID)
+	rows, err := r.db.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query notes: %w", err)
 	}
@@ -76,6 +223,7 @@ func (r *postgresNotesRepository) GetNotesByAuthor(ctx context.Context, authorID
 
 	for rows.Next() {
 		var n NoteSummary
+		var pathIDs, pathNames []string
 		err := rows.Scan(
 			&n.ID,
 			&n.Title,
@@ -83,10 +231,19 @@ func (r *postgresNotesRepository) GetNotesByAuthor(ctx context.Context, authorID
 			&n.Public,
 			&n.Slug,
 			&n.CreatedAt,
+			&n.UpdatedAt,
+			&n.Tags,
+			&n.FolderID,
+			&n.WorkspaceID,
+			&pathIDs,
+			&pathNames,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan note row: %w", err)
 		}
+		for i := range pathIDs {
+			n.FolderPath = append(n.FolderPath, FolderCrumb{ID: pathIDs[i], Name: pathNames[i]})
+		}
 		notes = append(notes, &n)
 	}
 
@@ -94,14 +251,29 @@ func (r *postgresNotesRepository) GetNotesByAuthor(ctx context.Context, authorID
 		return nil, fmt.Errorf("rows iteration error: %w", err)
 	}
 
-	return notes, nil
+	result := &NotePage{Notes: notes}
+
+	if len(notes) > page.Limit {
+		result.Notes = notes[:page.Limit]
+		last := result.Notes[page.Limit-1]
+		result.NextCursor = cursorAfter(page, last.ID, last.Title, last.CreatedAt, last.UpdatedAt)
+	}
+
+	if result.Notes == nil {
+		result.Notes = []*NoteSummary{}
+	}
+
+	return result, nil
 }
 
 func (r *postgresNotesRepository) GetNoteByID(ctx context.Context, noteID, authorID string) (*Note, error) {
 	query := `
-		SELECT id, author_id, title, content, public, slug, created_at, updated_at
-		FROM notes
-		WHERE author_id = $1 AND id = $2
+		SELECT n.id, n.author_id, n.title, n.content, n.public, n.slug, n.created_at, n.updated_at,
+		       ` + noteTagsColumn + `, n.folder_id, n.version, n.password_hash IS NOT NULL,
+		       ARRAY(SELECT ns.email FROM note_shares ns WHERE ns.note_id = n.id ORDER BY ns.email),
+		       n.workspace_id, ` + roleColumn(1, 0) + `
+		FROM notes n
+		WHERE n.id = $2 AND n.deleted_at IS NULL AND ` + memberCondition(1, 0) + `
 	`
 
 	var n Note
@@ -115,7 +287,17 @@ func (r *postgresNotesRepository) GetNoteByID(ctx context.Context, noteID, autho
 		&n.Slug,
 		&n.CreatedAt,
 		&n.UpdatedAt,
+		&n.Tags,
+		&n.FolderID,
+		&n.Version,
+		&n.PasswordProtected,
+		&n.SharedWith,
+		&n.WorkspaceID,
+		&n.Role,
 	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("could not find the requested note: %w", ErrNoteNotFound)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("could not find the requested note: %w", err)
 	}
@@ -123,9 +305,11 @@ func (r *postgresNotesRepository) GetNoteByID(ctx context.Context, noteID, autho
 	return &n, nil
 }
 
+// DeleteNote moves a note to its owner's trash. The row is only removed for
+// good by EmptyTrash or the background purger.
 func (r *postgresNotesRepository) DeleteNote(ctx context.Context, noteID, autourID string) error {
-	query := `DELETE FROM notes
-			WHERE id= $1 AND author_id = $2`
+	query := `UPDATE notes n SET deleted_at = NOW()
+			WHERE n.id= $1 AND n.deleted_at IS NULL AND ` + accessCondition(PermOwn, 2, 0)
 
 	cmdtag, err := r.db.Exec(ctx, query, noteID, autourID)
 
@@ -140,52 +324,90 @@ func (r *postgresNotesRepository) DeleteNote(ctx context.Context, noteID, autour
 	return nil
 }
 
-func (r *postgresNotesRepository) UpdateNote(ctx context.Context, n *Note) (*NoteSummary, error) {
+// UpdateNote overwrites a note on behalf of its owner or an editor. When
+// n.Version is set the write only happens if it is still the stored version;
+// the check and the bump are one statement, so two clients racing on the
+// same version cannot both win. The slug is left alone so shared links keep
+// working after a rename; owners change it with SetNoteSlug. Only owners may
+// change n.Public; editors must send the current value.
+func (r *postgresNotesRepository) UpdateNote(ctx context.Context, n *Note, caller Caller) (*NoteSummary, error) {
 	query := `
-		UPDATE notes
+		UPDATE notes n
 		SET title = $3,
 		    content = $4,
 		    public = $5,
-		    slug = $6,
+		    version = n.version + 1,
 		    updated_at = NOW()
-		WHERE author_id = $1 AND id = $2
-		RETURNING id, title, slug, public, created_at, author_id;
+		WHERE n.id = $2 AND n.deleted_at IS NULL
+		  AND ` + accessCondition(PermEdit, 1, 7) + `
+		  AND ($5::boolean = n.public OR ` + accessCondition(PermOwn, 1, 7) + `)
+		  AND ($6::int = 0 OR n.version = $6)
+		RETURNING n.id, n.title, n.slug, n.public, n.version, n.created_at, n.author_id;
 	`
 
-	newSlug := slugifyWithID(n.Title, n.ID)
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update note: %w", err)
+	}
+	defer tx.Rollback(ctx)
 
 	var summary NoteSummary
-	err := r.db.QueryRow(ctx, query,
-		n.AuthorID, // 🧠 now required to match logged-in user
+	err = tx.QueryRow(ctx, query,
+		caller.UserID, // 🧠 now required to be the owner or an editor
 		n.ID,
 		n.Title,
 		n.Content,
 		n.Public,
-		newSlug,
+		n.Version,
+		caller.Email,
 	).Scan(
 		&summary.ID,
 		&summary.Title,
 		&summary.Slug,
 		&summary.Public,
+		&summary.Version,
 		&summary.CreatedAt,
 		&summary.AuthorID,
 	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, r.updateMissError(ctx, tx, n.ID, caller, &n.Public)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to update note: %w", err)
 	}
 
+	if err := snapshotRevision(ctx, tx, n.ID, caller.UserID); err != nil {
+		return nil, err
+	}
+
+	// nil tags leave the current set untouched; an empty slice clears it.
+	// Tags always belong to the note's owner, whoever is editing.
+	if n.Tags != nil {
+		if err := setNoteTags(ctx, tx, n.ID, summary.AuthorID, n.Tags); err != nil {
+			return nil, err
+		}
+		summary.Tags = n.Tags
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to update note: %w", err)
+	}
+
 	return &summary, nil
 }
 
-func (r *postgresNotesRepository) AddEmailShare(ctx context.Context, noteID, ownerId, emailId string) error {
+func (r *postgresNotesRepository) AddEmailShare(ctx context.Context, noteID, ownerId, emailId string, role Role) error {
 	query := `
-INSERT INTO note_shares(note_id, email)
-SELECT n.id, $3
+INSERT INTO note_shares(note_id, email, role)
+SELECT n.id, $3, $4
 FROM notes n
-WHERE n.id = $1 AND n.author_id = $2;
+WHERE n.id = $1 AND n.deleted_at IS NULL AND ` + accessCondition(PermOwn, 2, 0) + `;
 `
 
-	cmdTag, err := r.db.Exec(ctx, query, noteID, ownerId, emailId)
+	cmdTag, err := r.db.Exec(ctx, query, noteID, ownerId, emailId, role)
+	if isUniqueViolation(err) {
+		return ErrShareExists
+	}
 	if err != nil {
 		return fmt.Errorf("failed to share note: %w", err)
 	}
@@ -203,8 +425,8 @@ func (r *postgresNotesRepository) RemoveEmailShare(ctx context.Context, noteID,
 	DELETE FROM note_shares
 	WHERE note_id = $1 AND email = $2
 	  AND EXISTS(
-	      SELECT 1 FROM notes 
-	      WHERE id = $1 AND author_id = $3
+	      SELECT 1 FROM notes n
+	      WHERE n.id = $1 AND ` + accessCondition(PermOwn, 3, 0) + `
 	  )
 	`
 
@@ -214,7 +436,7 @@ func (r *postgresNotesRepository) RemoveEmailShare(ctx context.Context, noteID,
 	}
 
 	if cmdTag.RowsAffected() == 0 {
-		return fmt.Errorf("unauthorized or share not found")
+		return ErrShareNotFound
 	}
 
 	return nil
@@ -234,10 +456,13 @@ func (r *postgresNotesRepository) GetNoteBySlug(
 		// Public user: can only see public notes
 		// -------------------------------
 		query = `
-            SELECT id, title, content, author_id, public, slug, created_at, updated_at
-            FROM notes
-            WHERE slug = $1
-              AND public = TRUE
+            SELECT n.id, n.title, n.content, n.author_id, n.public, n.slug,
+                   n.created_at, n.updated_at, n.version, ` + roleColumn(0, 0) + `,
+                   n.password_hash IS NOT NULL, ` + lockColumn(0, 0) + `
+            FROM notes n
+            WHERE n.slug = $1
+              AND n.deleted_at IS NULL
+              AND ` + accessCondition(PermRead, 0, 0) + `
             LIMIT 1
         `
 		args = []interface{}{slug}
@@ -245,19 +470,16 @@ func (r *postgresNotesRepository) GetNoteBySlug(
 	} else {
 		// -------------------------------
 		// Logged-in user:
-		// owner OR shared OR public
+		// owner OR shared (any role) OR public
 		// -------------------------------
 		query = `
             SELECT n.id, n.title, n.content, n.author_id, n.public, n.slug,
-                   n.created_at, n.updated_at
+                   n.created_at, n.updated_at, n.version, ` + roleColumn(2, 3) + `,
+                   n.password_hash IS NOT NULL, ` + lockColumn(2, 3) + `
             FROM notes n
-            LEFT JOIN note_shares ns ON n.id = ns.note_id
             WHERE n.slug = $1
-              AND (
-                    n.public = TRUE OR
-                    n.author_id = $2 OR
-                    ns.email = $3
-                  )
+              AND n.deleted_at IS NULL
+              AND ` + accessCondition(PermRead, 2, 3) + `
             LIMIT 1
         `
 
@@ -274,10 +496,1796 @@ func (r *postgresNotesRepository) GetNoteBySlug(
 		&note.Slug,
 		&note.CreatedAt,
 		&note.UpdatedAt,
+		&note.Version,
+		&note.Role,
+		&note.PasswordProtected,
+		&note.lockHash,
 	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoteNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("note not found or access denied: %w", err)
 	}
 
 	return &note, nil
 }
+
+// GetSlugRedirect returns the current slug of the note that used to go by
+// oldSlug, if the caller could read it under the rules of GetNoteBySlug.
+func (r *postgresNotesRepository) GetSlugRedirect(ctx context.Context, oldSlug string, userID, emailID *string) (string, error) {
+	condition := accessCondition(PermRead, 0, 0)
+	args := []any{oldSlug}
+	if userID != nil {
+		condition = accessCondition(PermRead, 2, 3)
+		args = append(args, *userID, *emailID)
+	}
+
+	var slug string
+	err := r.db.QueryRow(ctx, `
+	SELECT n.slug
+	FROM note_slug_history h
+	JOIN notes n ON n.id = h.note_id
+	WHERE h.slug = $1 AND n.slug IS NOT NULL AND n.deleted_at IS NULL AND `+condition,
+		args...,
+	).Scan(&slug)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNoteNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up slug history: %w", err)
+	}
+
+	return slug, nil
+}
+
+// SetNoteSlug gives a note a new slug, or one derived from its current title
+// when slug is empty, and returns it. The old slug goes into the history so
+// links using it keep resolving; it cannot be claimed by another note, but
+// the note itself may take it back.
+func (r *postgresNotesRepository) SetNoteSlug(ctx context.Context, noteID, ownerID, slug string) (string, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to set slug: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		title   string
+		current *string
+	)
+	err = tx.QueryRow(ctx, `
+	SELECT n.title, n.slug FROM notes n
+	WHERE n.id = $1 AND n.deleted_at IS NULL AND `+accessCondition(PermOwn, 2, 0)+`
+	FOR UPDATE
+	`, noteID, ownerID).Scan(&title, &current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNoteNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to set slug: %w", err)
+	}
+
+	if slug == "" {
+		slug = slugifyWithID(title, noteID)
+	}
+	if current != nil && *current == slug {
+		return slug, nil
+	}
+
+	var retiredBy string
+	err = tx.QueryRow(ctx, `SELECT note_id FROM note_slug_history WHERE slug = $1`, slug).Scan(&retiredBy)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("failed to check slug history: %w", err)
+	}
+	if err == nil && retiredBy != noteID {
+		return "", ErrSlugTaken
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE notes SET slug = $1 WHERE id = $2`, slug, noteID)
+	if isUniqueViolation(err) {
+		return "", ErrSlugTaken
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to set slug: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM note_slug_history WHERE slug = $1`, slug)
+	if err != nil {
+		return "", fmt.Errorf("failed to update slug history: %w", err)
+	}
+
+	if current != nil {
+		_, err = tx.Exec(ctx, `
+		INSERT INTO note_slug_history(slug, note_id) VALUES ($1, $2)
+		ON CONFLICT (slug) DO NOTHING
+		`, *current, noteID)
+		if err != nil {
+			return "", fmt.Errorf("failed to update slug history: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("failed to set slug: %w", err)
+	}
+
+	return slug, nil
+}
+
+func (r *postgresNotesRepository) ListCollaborators(ctx context.Context, noteID, ownerID string) ([]*Collaborator, error) {
+	var exists bool
+	err := r.db.QueryRow(ctx, `
+	SELECT EXISTS(SELECT 1 FROM notes n WHERE n.id = $1 AND n.deleted_at IS NULL AND `+accessCondition(PermOwn, 2, 0)+`)
+	`, noteID, ownerID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check note: %w", err)
+	}
+	if !exists {
+		return nil, ErrNoteNotFound
+	}
+
+	return listCollaborators(ctx, r.db, noteID)
+}
+
+// UpdateCollaborators removes and then adds shares in one transaction, so a
+// role can be changed by removing and re-adding an email in the same call.
+// Nothing is applied if any email is already shared or not shared.
+func (r *postgresNotesRepository) UpdateCollaborators(ctx context.Context, noteID, ownerID string, add []Collaborator, remove []string) ([]*Collaborator, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update collaborators: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var locked string
+	err = tx.QueryRow(ctx, `
+	SELECT n.id FROM notes n
+	WHERE n.id = $1 AND n.deleted_at IS NULL AND `+accessCondition(PermOwn, 2, 0)+`
+	FOR UPDATE
+	`, noteID, ownerID).Scan(&locked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoteNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update collaborators: %w", err)
+	}
+
+	for _, email := range remove {
+		cmdTag, err := tx.Exec(ctx, `DELETE FROM note_shares WHERE note_id = $1 AND email = $2`, noteID, email)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove share: %w", err)
+		}
+		if cmdTag.RowsAffected() == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrShareNotFound, email)
+		}
+	}
+
+	for _, c := range add {
+		_, err := tx.Exec(ctx, `INSERT INTO note_shares(note_id, email, role) VALUES ($1, $2, $3)`, noteID, c.Email, c.Role)
+		if isUniqueViolation(err) {
+			return nil, fmt.Errorf("%w: %s", ErrShareExists, c.Email)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to share note: %w", err)
+		}
+	}
+
+	collaborators, err := listCollaborators(ctx, tx, noteID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to update collaborators: %w", err)
+	}
+
+	return collaborators, nil
+}
+
+func (r *postgresNotesRepository) GetNoteHeader(ctx context.Context, noteID string) (*NoteHeader, error) {
+	query := `
+	SELECT n.id, n.title, n.slug, u.name, u.email
+	FROM notes n
+	JOIN users u ON u.id = n.author_id
+	WHERE n.id = $1
+	`
+
+	var h NoteHeader
+	err := r.db.QueryRow(ctx, query, noteID).Scan(&h.ID, &h.Title, &h.Slug, &h.OwnerName, &h.OwnerEmail)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoteNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch note header: %w", err)
+	}
+
+	return &h, nil
+}
+
+func listCollaborators(ctx context.Context, q querier, noteID string) ([]*Collaborator, error) {
+	rows, err := q.Query(ctx, `
+	SELECT email, role, created_at
+	FROM note_shares
+	WHERE note_id = $1
+	ORDER BY created_at, email
+	`, noteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query collaborators: %w", err)
+	}
+	defer rows.Close()
+
+	collaborators := []*Collaborator{}
+
+	for rows.Next() {
+		var c Collaborator
+		if err := rows.Scan(&c.Email, &c.Role, &c.SharedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan collaborator row: %w", err)
+		}
+		collaborators = append(collaborators, &c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return collaborators, nil
+}
+
+// querier is the part of pgxpool.Pool and pgx.Tx that read helpers need, so
+// they can run inside or outside a transaction.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// GetNotesSharedWith lists the live notes shared with email, paged like
+// GetNotesByAuthor. A note shared more than once shows up once, with its
+// strongest role and the date of its first share.
+func (r *postgresNotesRepository) GetNotesSharedWith(ctx context.Context, email string, page PageRequest) (*SharedNotePage, error) {
+	conditions := []string{"n.deleted_at IS NULL"}
+	args := []any{email}
+
+	cursorCond, args, orderBy, err := keyset(page, args)
+	if err != nil {
+		return nil, err
+	}
+	if cursorCond != "" {
+		conditions = append(conditions, cursorCond)
+	}
+
+	args = append(args, page.Limit+1)
+
+	query := `
+	SELECT n.id, n.title, n.slug, n.public, n.author_id, u.name, s.role, s.shared_at,
+	       n.created_at, n.updated_at
+	FROM (
+		SELECT ns.note_id, MIN(ns.created_at) AS shared_at,
+		       (ARRAY_AGG(ns.role ORDER BY array_position(ARRAY[` + sqlRoleList(roleRank) + `], ns.role)))[1] AS role
+		FROM note_shares ns
+		WHERE ns.email = $1 AND ` + verifiedEmail(1) + `
+		GROUP BY ns.note_id
+	) s
+	JOIN notes n ON n.id = s.note_id
+	JOIN users u ON u.id = n.author_id
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY ` + orderBy + `
+	LIMIT $` + strconv.Itoa(len(args))
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shared notes: %w", err)
+	}
+	defer rows.Close()
+
+	var notes []*SharedNote
+
+	for rows.Next() {
+		var n SharedNote
+		err := rows.Scan(
+			&n.ID,
+			&n.Title,
+			&n.Slug,
+			&n.Public,
+			&n.OwnerID,
+			&n.OwnerName,
+			&n.Role,
+			&n.SharedAt,
+			&n.CreatedAt,
+			&n.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan shared note row: %w", err)
+		}
+		notes = append(notes, &n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	result := &SharedNotePage{Notes: notes}
+
+	if len(notes) > page.Limit {
+		result.Notes = notes[:page.Limit]
+		last := result.Notes[page.Limit-1]
+		result.NextCursor = cursorAfter(page, last.ID, last.Title, last.CreatedAt, last.UpdatedAt)
+	}
+
+	if result.Notes == nil {
+		result.Notes = []*SharedNote{}
+	}
+
+	return result, nil
+}
+
+// SetNotePassword sets or, with a nil hash, clears the password of a note
+// the caller owns.
+func (r *postgresNotesRepository) SetNotePassword(ctx context.Context, noteID, ownerID string, passwordHash *string) error {
+	query := `
+	UPDATE notes n
+	SET password_hash = $3
+	WHERE n.id = $1 AND n.deleted_at IS NULL AND ` + accessCondition(PermOwn, 2, 0)
+
+	cmdTag, err := r.db.Exec(ctx, query, noteID, ownerID, passwordHash)
+	if err != nil {
+		return fmt.Errorf("failed to set note password: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return ErrNoteNotFound
+	}
+
+	return nil
+}
+
+func (r *postgresNotesRepository) CreateShareLink(ctx context.Context, link *ShareLink, ownerID, tokenHash string) (*ShareLink, error) {
+	query := `
+	INSERT INTO share_links(note_id, created_by, token_hash, expires_at, max_views)
+	SELECT n.id, $2, $3, $4, $5
+	FROM notes n
+	WHERE n.id = $1 AND n.deleted_at IS NULL AND ` + accessCondition(PermOwn, 2, 0) + `
+	RETURNING id, view_count, created_at
+	`
+
+	err := r.db.QueryRow(ctx, query,
+		link.NoteID,
+		ownerID,
+		tokenHash,
+		link.ExpiresAt,
+		link.MaxViews,
+	).Scan(&link.ID, &link.ViewCount, &link.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoteNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create share link: %w", err)
+	}
+
+	return link, nil
+}
+
+func (r *postgresNotesRepository) ListShareLinks(ctx context.Context, noteID, ownerID string) ([]*ShareLink, error) {
+	query := `
+	SELECT sl.id, sl.note_id, sl.expires_at, sl.max_views, sl.view_count, sl.revoked_at, sl.created_at
+	FROM share_links sl
+	JOIN notes n ON n.id = sl.note_id
+	WHERE sl.note_id = $1 AND ` + accessCondition(PermOwn, 2, 0) + `
+	ORDER BY sl.created_at DESC
+	`
+
+	rows, err := r.db.Query(ctx, query, noteID, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query share links: %w", err)
+	}
+	defer rows.Close()
+
+	links := []*ShareLink{}
+
+	for rows.Next() {
+		var l ShareLink
+		err := rows.Scan(&l.ID, &l.NoteID, &l.ExpiresAt, &l.MaxViews, &l.ViewCount, &l.RevokedAt, &l.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan share link row: %w", err)
+		}
+		links = append(links, &l)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return links, nil
+}
+
+func (r *postgresNotesRepository) RevokeShareLink(ctx context.Context, linkID, ownerID string) error {
+	query := `
+	UPDATE share_links sl
+	SET revoked_at = NOW()
+	FROM notes n
+	WHERE sl.id = $1 AND sl.revoked_at IS NULL
+	  AND n.id = sl.note_id AND ` + accessCondition(PermOwn, 2, 0)
+
+	cmdTag, err := r.db.Exec(ctx, query, linkID, ownerID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke share link: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return ErrShareLinkNotFound
+	}
+
+	return nil
+}
+
+// GetNoteByShareLink redeems a link token for its note, counting the view.
+// The limits are checked in the same UPDATE that counts, so concurrent
+// readers cannot push a link past max_views.
+func (r *postgresNotesRepository) GetNoteByShareLink(ctx context.Context, tokenHash string) (*Note, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open share link: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var noteID string
+	err = tx.QueryRow(ctx, `
+	UPDATE share_links
+	SET view_count = view_count + 1
+	WHERE token_hash = $1
+	  AND revoked_at IS NULL
+	  AND (expires_at IS NULL OR expires_at > NOW())
+	  AND (max_views IS NULL OR view_count < max_views)
+	RETURNING note_id
+	`, tokenHash).Scan(&noteID)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		var exists bool
+		err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM share_links WHERE token_hash = $1)`, tokenHash).Scan(&exists)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open share link: %w", err)
+		}
+		if exists {
+			return nil, ErrShareLinkGone
+		}
+		return nil, ErrShareLinkNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open share link: %w", err)
+	}
+
+	var note Note
+	err = tx.QueryRow(ctx, `
+	SELECT id, title, content, author_id, public, slug, created_at, updated_at, version
+	FROM notes
+	WHERE id = $1 AND deleted_at IS NULL
+	`, noteID).Scan(
+		&note.ID,
+		&note.Title,
+		&note.Content,
+		&note.AuthorID,
+		&note.Public,
+		&note.Slug,
+		&note.CreatedAt,
+		&note.UpdatedAt,
+		&note.Version,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrShareLinkGone
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open share link: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to open share link: %w", err)
+	}
+
+	note.Role = RoleViewer
+	return &note, nil
+}
+
+func (r *postgresNotesRepository) RecordViews(ctx context.Context, views []NoteView) error {
+	rows := make([][]any, len(views))
+	for i, v := range views {
+		var referrer *string
+		if v.Referrer != "" {
+			referrer = &v.Referrer
+		}
+		rows[i] = []any{v.NoteID, v.ViewedAt, v.Authenticated, v.ViewerKey, referrer, string(v.AgentClass)}
+	}
+
+	_, err := r.db.CopyFrom(ctx,
+		pgx.Identifier{"note_views"},
+		[]string{"note_id", "viewed_at", "authenticated", "viewer_key", "referrer", "agent_class"},
+		pgx.CopyFromRows(rows),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record views: %w", err)
+	}
+
+	return nil
+}
+
+// GetViewStats sums up the views of a note the caller owns between from and
+// to. Buckets are interval long (hour, day, week or month) and start at
+// the truncated from; empty buckets are included so the series has no gaps.
+func (r *postgresNotesRepository) GetViewStats(ctx context.Context, noteID, ownerID string, from, to time.Time, interval string) (*ViewStats, error) {
+	var exists bool
+	err := r.db.QueryRow(ctx, `
+	SELECT EXISTS(SELECT 1 FROM notes n WHERE n.id = $1 AND n.deleted_at IS NULL AND `+accessCondition(PermOwn, 2, 0)+`)
+	`, noteID, ownerID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check note: %w", err)
+	}
+	if !exists {
+		return nil, ErrNoteNotFound
+	}
+
+	stats := &ViewStats{
+		NoteID:    noteID,
+		From:      from,
+		To:        to,
+		Interval:  interval,
+		Buckets:   []ViewBucket{},
+		Referrers: []ViewCount{},
+		Agents:    []ViewCount{},
+	}
+
+	err = r.db.QueryRow(ctx, `
+	SELECT count(*), count(DISTINCT viewer_key), count(*) FILTER (WHERE authenticated)
+	FROM note_views
+	WHERE note_id = $1 AND viewed_at >= $2 AND viewed_at < $3
+	`, noteID, from, to).Scan(&stats.Views, &stats.UniqueViewers, &stats.Authenticated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count views: %w", err)
+	}
+	stats.Anonymous = stats.Views - stats.Authenticated
+
+	rows, err := r.db.Query(ctx, `
+	SELECT b.start, count(v.id), count(DISTINCT v.viewer_key)
+	FROM generate_series(date_trunc($4, $2::timestamptz), $3::timestamptz - interval '1 microsecond', ('1 ' || $4)::interval) AS b(start)
+	LEFT JOIN note_views v
+	  ON v.note_id = $1
+	 AND v.viewed_at >= GREATEST(b.start, $2) AND v.viewed_at < LEAST(b.start + ('1 ' || $4)::interval, $3)
+	GROUP BY b.start
+	ORDER BY b.start
+	`, noteID, from, to, interval)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query view buckets: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b ViewBucket
+		if err := rows.Scan(&b.Start, &b.Views, &b.UniqueViewers); err != nil {
+			return nil, fmt.Errorf("failed to scan view bucket: %w", err)
+		}
+		stats.Buckets = append(stats.Buckets, b)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	stats.Referrers, err = countViewsBy(ctx, r.db, "COALESCE(referrer, 'direct')", noteID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Agents, err = countViewsBy(ctx, r.db, "agent_class", noteID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// countViewsBy groups a note's views between from and to on column and
+// returns the ten largest groups.
+func countViewsBy(ctx context.Context, q querier, column, noteID string, from, to time.Time) ([]ViewCount, error) {
+	rows, err := q.Query(ctx, `
+	SELECT `+column+` AS name, count(*) AS views
+	FROM note_views
+	WHERE note_id = $1 AND viewed_at >= $2 AND viewed_at < $3
+	GROUP BY name
+	ORDER BY views DESC, name
+	LIMIT 10
+	`, noteID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count views: %w", err)
+	}
+	defer rows.Close()
+
+	counts := []ViewCount{}
+
+	for rows.Next() {
+		var c ViewCount
+		if err := rows.Scan(&c.Name, &c.Views); err != nil {
+			return nil, fmt.Errorf("failed to scan view count: %w", err)
+		}
+		counts = append(counts, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return counts, nil
+}
+
+// ProposeTransfers offers live personal notes of ownerID to the user with
+// toEmail: the notes in noteIDs, or all of them when noteIDs is nil. A bulk
+// proposal skips notes that already have a transfer pending; a named note
+// that does fails the whole proposal with ErrTransferPending.
+func (r *postgresNotesRepository) ProposeTransfers(ctx context.Context, ownerID, toEmail string, noteIDs []string, keepEditor bool) ([]*NoteTransfer, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to propose transfer: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var toUserID string
+	err = tx.QueryRow(ctx, `SELECT id FROM users WHERE email = $1`, toEmail).Scan(&toUserID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTransferRecipient
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up recipient: %w", err)
+	}
+	if toUserID == ownerID {
+		return nil, ErrTransferRecipient
+	}
+
+	owned := "n.author_id = $1 AND n.workspace_id IS NULL AND n.deleted_at IS NULL"
+	args := []any{ownerID}
+	if noteIDs != nil {
+		owned += " AND n.id = ANY($2)"
+		args = append(args, noteIDs)
+
+		var count int
+		if err := tx.QueryRow(ctx, `SELECT count(*) FROM notes n WHERE `+owned, args...).Scan(&count); err != nil {
+			return nil, fmt.Errorf("failed to check notes: %w", err)
+		}
+		if count < len(noteIDs) {
+			return nil, ErrNoteNotFound
+		}
+	}
+
+	var batchID string
+	if err := tx.QueryRow(ctx, `SELECT gen_random_uuid()`).Scan(&batchID); err != nil {
+		return nil, fmt.Errorf("failed to propose transfer: %w", err)
+	}
+
+	n := len(args)
+	cmdTag, err := tx.Exec(ctx, fmt.Sprintf(`
+	INSERT INTO note_transfers(batch_id, note_id, from_user_id, to_user_id, keep_editor)
+	SELECT $%d, n.id, n.author_id, $%d, $%d
+	FROM notes n
+	WHERE %s
+	ON CONFLICT (note_id) DO NOTHING
+	`, n+1, n+2, n+3, owned), append(args, batchID, toUserID, keepEditor)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to propose transfer: %w", err)
+	}
+	if noteIDs != nil && cmdTag.RowsAffected() < int64(len(noteIDs)) {
+		return nil, ErrTransferPending
+	}
+
+	transfers, err := listTransfers(ctx, tx, "t.batch_id = $1", batchID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to propose transfer: %w", err)
+	}
+
+	return transfers, nil
+}
+
+func (r *postgresNotesRepository) ListTransfers(ctx context.Context, userID string) (*TransferList, error) {
+	incoming, err := listTransfers(ctx, r.db, "t.to_user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	outgoing, err := listTransfers(ctx, r.db, "t.from_user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TransferList{Incoming: incoming, Outgoing: outgoing}, nil
+}
+
+// AcceptTransfers makes userID the owner of the note in transfer id, or of
+// every note in batch id when batch is set. Shares and share links are left
+// as they are. The previous owner is kept as an editor when the transfer
+// asks for it, and the new owner's own share, now redundant, is dropped. A
+// note whose owner changed since the proposal, or that is in the trash, is
+// skipped. It returns the ids
+// of the notes that changed hands.
+func (r *postgresNotesRepository) AcceptTransfers(ctx context.Context, userID, id string, batch bool) ([]string, error) {
+	column := "id"
+	if batch {
+		column = "batch_id"
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to accept transfer: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	type pending struct {
+		noteID     string
+		fromUserID string
+		keepEditor bool
+	}
+
+	rows, err := tx.Query(ctx, `
+	DELETE FROM note_transfers
+	WHERE to_user_id = $1 AND `+column+` = $2
+	RETURNING note_id, from_user_id, keep_editor
+	`, userID, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to accept transfer: %w", err)
+	}
+
+	var accepted []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.noteID, &p.fromUserID, &p.keepEditor); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan transfer row: %w", err)
+		}
+		accepted = append(accepted, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	if len(accepted) == 0 {
+		return nil, ErrTransferNotFound
+	}
+
+	moved := []string{}
+
+	for _, p := range accepted {
+		// folders are personal, so the note lands at the new owner's root
+		cmdTag, err := tx.Exec(ctx, `
+		UPDATE notes
+		SET author_id = $3, folder_id = NULL
+		WHERE id = $1 AND author_id = $2 AND workspace_id IS NULL AND deleted_at IS NULL
+		`, p.noteID, p.fromUserID, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to transfer note: %w", err)
+		}
+		if cmdTag.RowsAffected() == 0 {
+			continue
+		}
+
+		if err := moveNoteTags(ctx, tx, p.noteID, userID); err != nil {
+			return nil, err
+		}
+
+		if p.keepEditor {
+			_, err := tx.Exec(ctx, `
+			INSERT INTO note_shares(note_id, email, role)
+			SELECT $1, u.email, 'editor' FROM users u WHERE u.id = $2
+			ON CONFLICT (note_id, email) DO UPDATE SET role = EXCLUDED.role
+			`, p.noteID, p.fromUserID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to share note with previous owner: %w", err)
+			}
+		}
+
+		_, err = tx.Exec(ctx, `
+		DELETE FROM note_shares ns
+		USING users u
+		WHERE ns.note_id = $1 AND u.id = $2 AND ns.email = u.email
+		`, p.noteID, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove share: %w", err)
+		}
+
+		moved = append(moved, p.noteID)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to accept transfer: %w", err)
+	}
+
+	return moved, nil
+}
+
+// CancelTransfers drops transfer id, or every transfer in batch id when
+// batch is set. Either side may cancel: for the recipient it is a decline.
+func (r *postgresNotesRepository) CancelTransfers(ctx context.Context, userID, id string, batch bool) (int64, error) {
+	column := "id"
+	if batch {
+		column = "batch_id"
+	}
+
+	cmdTag, err := r.db.Exec(ctx, `
+	DELETE FROM note_transfers
+	WHERE `+column+` = $2 AND (from_user_id = $1 OR to_user_id = $1)
+	`, userID, id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to cancel transfer: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return 0, ErrTransferNotFound
+	}
+
+	return cmdTag.RowsAffected(), nil
+}
+
+func listTransfers(ctx context.Context, q querier, where string, args ...any) ([]*NoteTransfer, error) {
+	rows, err := q.Query(ctx, `
+	SELECT t.id, t.batch_id, t.note_id, n.title, t.from_user_id, fu.email, t.to_user_id, tu.email, t.keep_editor, t.created_at
+	FROM note_transfers t
+	JOIN notes n ON n.id = t.note_id
+	JOIN users fu ON fu.id = t.from_user_id
+	JOIN users tu ON tu.id = t.to_user_id
+	WHERE `+where+`
+	ORDER BY t.created_at DESC, n.title
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transfers: %w", err)
+	}
+	defer rows.Close()
+
+	transfers := []*NoteTransfer{}
+
+	for rows.Next() {
+		var t NoteTransfer
+		err := rows.Scan(&t.ID, &t.BatchID, &t.NoteID, &t.NoteTitle, &t.FromUserID, &t.FromEmail, &t.ToUserID, &t.ToEmail, &t.KeepEditor, &t.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan transfer row: %w", err)
+		}
+		transfers = append(transfers, &t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return transfers, nil
+}
+
+// moveNoteTags points a note's tags at the same-named tags of newOwnerID,
+// creating any the new owner does not have yet. The previous owner keeps
+// their tags.
+func moveNoteTags(ctx context.Context, tx pgx.Tx, noteID, newOwnerID string) error {
+	_, err := tx.Exec(ctx, `
+	INSERT INTO tags(owner_id, name)
+	SELECT $2, t.name
+	FROM note_tags nt
+	JOIN tags t ON t.id = nt.tag_id
+	WHERE nt.note_id = $1
+	ON CONFLICT (owner_id, name) DO NOTHING
+	`, noteID, newOwnerID)
+	if err != nil {
+		return fmt.Errorf("failed to create tags: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+	UPDATE note_tags nt
+	SET tag_id = mine.id
+	FROM tags old, tags mine
+	WHERE nt.note_id = $1 AND old.id = nt.tag_id AND old.owner_id <> $2
+	  AND mine.owner_id = $2 AND mine.name = old.name
+	`, noteID, newOwnerID)
+	if err != nil {
+		return fmt.Errorf("failed to move note tags: %w", err)
+	}
+
+	return nil
+}
+
+// PatchNote applies only the fields set in patch. Like UpdateNote it never
+// touches the slug.
+func (r *postgresNotesRepository) PatchNote(ctx context.Context, noteID string, caller Caller, patch NotePatch) (*NoteSummary, error) {
+	query := `
+		UPDATE notes n
+		SET title = COALESCE($3, n.title),
+		    content = COALESCE($4, n.content),
+		    public = COALESCE($5, n.public),
+		    version = n.version + 1,
+		    updated_at = NOW()
+		WHERE n.id = $2 AND n.deleted_at IS NULL
+		  AND ` + accessCondition(PermEdit, 1, 7) + `
+		  AND ($5::boolean IS NULL OR $5 = n.public OR ` + accessCondition(PermOwn, 1, 7) + `)
+		  AND ($6::int = 0 OR n.version = $6)
+		RETURNING n.id, n.title, n.slug, n.public, n.version, n.created_at, n.author_id;
+	`
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to patch note: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var summary NoteSummary
+	err = tx.QueryRow(ctx, query,
+		caller.UserID,
+		noteID,
+		patch.Title,
+		patch.Content,
+		patch.Public,
+		patch.Version,
+		caller.Email,
+	).Scan(
+		&summary.ID,
+		&summary.Title,
+		&summary.Slug,
+		&summary.Public,
+		&summary.Version,
+		&summary.CreatedAt,
+		&summary.AuthorID,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, r.updateMissError(ctx, tx, noteID, caller, patch.Public)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to patch note: %w", err)
+	}
+
+	if err := snapshotRevision(ctx, tx, noteID, caller.UserID); err != nil {
+		return nil, err
+	}
+
+	if patch.Tags != nil {
+		if err := setNoteTags(ctx, tx, noteID, summary.AuthorID, patch.Tags); err != nil {
+			return nil, err
+		}
+		summary.Tags = patch.Tags
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to patch note: %w", err)
+	}
+
+	return &summary, nil
+}
+
+// updateMissError explains why a conditional update touched no rows: the
+// note is gone or out of reach, an editor tried to change whether it is
+// public (public is what the update asked for, nil if nothing), or the
+// version no longer matched.
+func (r *postgresNotesRepository) updateMissError(ctx context.Context, tx pgx.Tx, noteID string, caller Caller, public *bool) error {
+	var (
+		current  int
+		isPublic bool
+		ownsNote bool
+	)
+	err := tx.QueryRow(ctx,
+		`SELECT n.version, n.public, `+accessCondition(PermOwn, 2, 3)+` FROM notes n
+		 WHERE n.id = $1 AND n.deleted_at IS NULL AND `+accessCondition(PermEdit, 2, 3),
+		noteID, caller.UserID, caller.Email,
+	).Scan(&current, &isPublic, &ownsNote)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNoteNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update note: %w", err)
+	}
+
+	if public != nil && *public != isPublic && !ownsNote {
+		return ErrVisibilityForbidden
+	}
+
+	return &VersionConflictError{CurrentVersion: current}
+}
+
+// snapshotRevision copies the current state of a note into note_revisions as
+// the next revision number. It must run inside the same transaction as the
+// write it records; the preceding UPDATE holds the row lock on the note, so
+// concurrent writers cannot race for the same revision number.
+func snapshotRevision(ctx context.Context, tx pgx.Tx, noteID, editorID string) error {
+	query := `
+	INSERT INTO note_revisions(note_id, revision, title, content, public, editor_id)
+	SELECT n.id,
+	       COALESCE((SELECT MAX(revision) FROM note_revisions WHERE note_id = n.id), 0) + 1,
+	       n.title, n.content, n.public, $2
+	FROM notes n
+	WHERE n.id = $1
+	`
+
+	if _, err := tx.Exec(ctx, query, noteID, editorID); err != nil {
+		return fmt.Errorf("failed to record revision: %w", err)
+	}
+
+	return nil
+}
+
+func (r *postgresNotesRepository) ListRevisions(ctx context.Context, noteID, authorID string) ([]*RevisionSummary, error) {
+	query := `
+	SELECT rv.revision, rv.title, rv.public, rv.editor_id, rv.created_at
+	FROM note_revisions rv
+	JOIN notes n ON n.id = rv.note_id
+	WHERE rv.note_id = $1 AND n.deleted_at IS NULL AND ` + memberCondition(2, 0) + `
+	ORDER BY rv.revision DESC
+	`
+
+	rows, err := r.db.Query(ctx, query, noteID, authorID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []*RevisionSummary
+
+	for rows.Next() {
+		var rv RevisionSummary
+		if err := rows.Scan(&rv.Revision, &rv.Title, &rv.Public, &rv.EditorID, &rv.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan revision row: %w", err)
+		}
+		revisions = append(revisions, &rv)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	// every note has at least its creation revision, so an empty list
+	// means the note does not exist or belongs to someone else.
+	if len(revisions) == 0 {
+		return nil, ErrNoteNotFound
+	}
+
+	return revisions, nil
+}
+
+func (r *postgresNotesRepository) GetRevision(ctx context.Context, noteID, authorID string, revision int) (*NoteRevision, error) {
+	query := `
+	SELECT rv.id, rv.note_id, rv.revision, rv.title, rv.content, rv.public, rv.editor_id, rv.created_at
+	FROM note_revisions rv
+	JOIN notes n ON n.id = rv.note_id
+	WHERE rv.note_id = $1 AND rv.revision = $3 AND n.deleted_at IS NULL AND ` + memberCondition(2, 0) + `
+	`
+
+	var rv NoteRevision
+	err := r.db.QueryRow(ctx, query, noteID, authorID, revision).Scan(
+		&rv.ID,
+		&rv.NoteID,
+		&rv.Revision,
+		&rv.Title,
+		&rv.Content,
+		&rv.Public,
+		&rv.EditorID,
+		&rv.CreatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRevisionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch revision: %w", err)
+	}
+
+	return &rv, nil
+}
+
+// RestoreRevision makes a revision the note's head again, recorded as a new
+// revision. Visibility is restored too, but only for callers who own the
+// note; for anyone else it stays as it is, as in UpdateNote.
+func (r *postgresNotesRepository) RestoreRevision(ctx context.Context, noteID, authorID string, revision int) (*NoteSummary, error) {
+	query := `
+	UPDATE notes n
+	SET title = rv.title,
+	    content = rv.content,
+	    public = CASE WHEN ` + accessCondition(PermOwn, 2, 0) + ` THEN rv.public ELSE n.public END,
+	    version = n.version + 1,
+	    updated_at = NOW()
+	FROM note_revisions rv
+	WHERE n.id = $1 AND n.deleted_at IS NULL AND ` + accessCondition(PermEdit, 2, 0) + `
+	  AND rv.note_id = n.id AND rv.revision = $3
+	RETURNING n.id, n.title, n.slug, n.public, n.version, n.created_at, n.author_id
+	`
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore revision: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var summary NoteSummary
+	err = tx.QueryRow(ctx, query, noteID, authorID, revision).Scan(
+		&summary.ID,
+		&summary.Title,
+		&summary.Slug,
+		&summary.Public,
+		&summary.Version,
+		&summary.CreatedAt,
+		&summary.AuthorID,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRevisionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore revision: %w", err)
+	}
+
+	if err := snapshotRevision(ctx, tx, noteID, authorID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to restore revision: %w", err)
+	}
+
+	return &summary, nil
+}
+
+// Search snippets are built from raw content, so ts_headline marks matches
+// with control characters (stripped from the content first) rather than
+// tags; highlightSnippet escapes the rest and only then adds the tags.
+const (
+	snippetStartSel = "\x02"
+	snippetStopSel  = "\x03"
+)
+
+func highlightSnippet(raw string) string {
+	escaped := html.EscapeString(raw)
+	escaped = strings.ReplaceAll(escaped, snippetStartSel, "<mark>")
+	return strings.ReplaceAll(escaped, snippetStopSel, "</mark>")
+}
+
+func (r *postgresNotesRepository) Search(ctx context.Context, userID, email, query string, limit int) ([]*SearchResult, error) {
+	sqlQuery := `
+	WITH q AS (SELECT websearch_to_tsquery('english', $3) AS query)
+	SELECT n.id, n.author_id, n.title, n.public, n.slug,
+	       (n.workspace_id IS NULL AND n.author_id = $1) AS owned,
+	       ts_rank(n.search_vector, q.query) AS rank,
+	       ts_headline('english', translate(n.content, chr(2) || chr(3), ''), q.query,
+	                   'StartSel=' || chr(2) || ', StopSel=' || chr(3) ||
+	                   ', MaxWords=35, MinWords=15, MaxFragments=2'),
+	       n.created_at, n.updated_at
+	FROM notes n, q
+	WHERE n.search_vector @@ q.query
+	  AND n.deleted_at IS NULL
+	  AND ` + memberCondition(1, 2) + `
+	ORDER BY rank DESC, n.updated_at DESC
+	LIMIT $4
+	`
+
+	rows, err := r.db.Query(ctx, sqlQuery, userID, email, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search notes: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*SearchResult
+
+	for rows.Next() {
+		var res SearchResult
+		err := rows.Scan(
+			&res.ID,
+			&res.AuthorID,
+			&res.Title,
+			&res.Public,
+			&res.Slug,
+			&res.Owned,
+			&res.Rank,
+			&res.Snippet,
+			&res.CreatedAt,
+			&res.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search row: %w", err)
+		}
+		res.Snippet = highlightSnippet(res.Snippet)
+		results = append(results, &res)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return results, nil
+}
+
+// setNoteTags makes tags the exact tag set of a note, creating any tag the
+// owner does not have yet. Tags left without notes are kept so they still
+// show up (with a zero count) in the owner's tag list.
+func setNoteTags(ctx context.Context, tx pgx.Tx, noteID, ownerID string, tags []string) error {
+	if tags == nil {
+		tags = []string{}
+	}
+
+	_, err := tx.Exec(ctx, `
+	INSERT INTO tags(owner_id, name)
+	SELECT $1, unnest($2::text[])
+	ON CONFLICT (owner_id, name) DO NOTHING
+	`, ownerID, tags)
+	if err != nil {
+		return fmt.Errorf("failed to create tags: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+	DELETE FROM note_tags nt
+	USING tags t
+	WHERE nt.tag_id = t.id AND nt.note_id = $1 AND NOT (t.name = ANY($2))
+	`, noteID, tags)
+	if err != nil {
+		return fmt.Errorf("failed to remove note tags: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+	INSERT INTO note_tags(note_id, tag_id)
+	SELECT $1, t.id FROM tags t
+	WHERE t.owner_id = $2 AND t.name = ANY($3)
+	ON CONFLICT DO NOTHING
+	`, noteID, ownerID, tags)
+	if err != nil {
+		return fmt.Errorf("failed to add note tags: %w", err)
+	}
+
+	return nil
+}
+
+func (r *postgresNotesRepository) ListTags(ctx context.Context, ownerID string) ([]*TagCount, error) {
+	query := `
+	SELECT t.name, COUNT(n.id)
+	FROM tags t
+	LEFT JOIN note_tags nt ON nt.tag_id = t.id
+	LEFT JOIN notes n ON n.id = nt.note_id AND n.deleted_at IS NULL
+	WHERE t.owner_id = $1
+	GROUP BY t.id, t.name
+	ORDER BY t.name
+	`
+
+	rows, err := r.db.Query(ctx, query, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tags: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []*TagCount
+
+	for rows.Next() {
+		var t TagCount
+		if err := rows.Scan(&t.Name, &t.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan tag row: %w", err)
+		}
+		tags = append(tags, &t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return tags, nil
+}
+
+func (r *postgresNotesRepository) RenameTag(ctx context.Context, ownerID, from, to string) error {
+	cmdTag, err := r.db.Exec(ctx,
+		`UPDATE tags SET name = $3 WHERE owner_id = $1 AND name = $2`,
+		ownerID, from, to,
+	)
+
+	if isUniqueViolation(err) {
+		return ErrTagExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rename tag: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return ErrTagNotFound
+	}
+
+	return nil
+}
+
+func (r *postgresNotesRepository) MergeTags(ctx context.Context, ownerID string, sources []string, target string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to merge tags: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+	INSERT INTO tags(owner_id, name) VALUES ($1, $2)
+	ON CONFLICT (owner_id, name) DO NOTHING
+	`, ownerID, target)
+	if err != nil {
+		return fmt.Errorf("failed to create target tag: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+	INSERT INTO note_tags(note_id, tag_id)
+	SELECT nt.note_id, dst.id
+	FROM note_tags nt
+	JOIN tags src ON src.id = nt.tag_id
+	JOIN tags dst ON dst.owner_id = src.owner_id AND dst.name = $3
+	WHERE src.owner_id = $1 AND src.name = ANY($2)
+	ON CONFLICT DO NOTHING
+	`, ownerID, sources, target)
+	if err != nil {
+		return fmt.Errorf("failed to retag notes: %w", err)
+	}
+
+	cmdTag, err := tx.Exec(ctx,
+		`DELETE FROM tags WHERE owner_id = $1 AND name = ANY($2) AND name <> $3`,
+		ownerID, sources, target,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete merged tags: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return ErrTagNotFound
+	}
+
+	return tx.Commit(ctx)
+}
+
+// checkFolderOwner returns ErrFolderNotFound unless folderID is a folder
+// belonging to ownerID.
+func checkFolderOwner(ctx context.Context, tx pgx.Tx, ownerID, folderID string) error {
+	var exists bool
+	err := tx.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM folders WHERE id = $1 AND owner_id = $2)`,
+		folderID, ownerID,
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to look up folder: %w", err)
+	}
+
+	if !exists {
+		return ErrFolderNotFound
+	}
+
+	return nil
+}
+
+func (r *postgresNotesRepository) CreateFolder(ctx context.Context, f *Folder) (*Folder, error) {
+	query := `
+	INSERT INTO folders(owner_id, parent_id, name)
+	SELECT $1, $2, $3
+	WHERE $2::uuid IS NULL
+	   OR EXISTS (SELECT 1 FROM folders WHERE id = $2 AND owner_id = $1)
+	RETURNING id, created_at, updated_at
+	`
+
+	err := r.db.QueryRow(ctx, query, f.OwnerID, f.ParentID, f.Name).Scan(&f.ID, &f.CreatedAt, &f.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrFolderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create folder: %w", err)
+	}
+
+	return f, nil
+}
+
+func (r *postgresNotesRepository) ListFolders(ctx context.Context, ownerID string) ([]*Folder, error) {
+	query := `
+	SELECT f.id, f.owner_id, f.parent_id, f.name,
+	       (SELECT COUNT(*) FROM notes n WHERE n.folder_id = f.id AND n.deleted_at IS NULL),
+	       f.created_at, f.updated_at
+	FROM folders f
+	WHERE f.owner_id = $1
+	ORDER BY f.name
+	`
+
+	rows, err := r.db.Query(ctx, query, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query folders: %w", err)
+	}
+	defer rows.Close()
+
+	var folders []*Folder
+
+	for rows.Next() {
+		var f Folder
+		err := rows.Scan(&f.ID, &f.OwnerID, &f.ParentID, &f.Name, &f.NoteCount, &f.CreatedAt, &f.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan folder row: %w", err)
+		}
+		folders = append(folders, &f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return folders, nil
+}
+
+func (r *postgresNotesRepository) MoveFolder(ctx context.Context, ownerID, folderID string, parentID *string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to move folder: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// lock the owner's folders so a concurrent move cannot build a cycle
+	// between our check and our update
+	_, err = tx.Exec(ctx, `SELECT id FROM folders WHERE owner_id = $1 FOR UPDATE`, ownerID)
+	if err != nil {
+		return fmt.Errorf("failed to lock folders: %w", err)
+	}
+
+	if parentID != nil {
+		if err := checkFolderOwner(ctx, tx, ownerID, *parentID); err != nil {
+			return err
+		}
+
+		var cycle bool
+		err := tx.QueryRow(ctx, `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM folders WHERE id = $1
+			UNION ALL
+			SELECT f.id FROM folders f JOIN subtree s ON f.parent_id = s.id
+		)
+		SELECT EXISTS(SELECT 1 FROM subtree WHERE id = $2)
+		`, folderID, *parentID).Scan(&cycle)
+		if err != nil {
+			return fmt.Errorf("failed to check folder ancestry: %w", err)
+		}
+
+		if cycle {
+			return ErrFolderCycle
+		}
+	}
+
+	cmdTag, err := tx.Exec(ctx,
+		`UPDATE folders SET parent_id = $3, updated_at = NOW() WHERE id = $1 AND owner_id = $2`,
+		folderID, ownerID, parentID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to move folder: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return ErrFolderNotFound
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *postgresNotesRepository) DeleteFolder(ctx context.Context, ownerID, folderID string, mode FolderDeleteMode) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete folder: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var parentID *string
+	err = tx.QueryRow(ctx,
+		`SELECT parent_id FROM folders WHERE id = $1 AND owner_id = $2 FOR UPDATE`,
+		folderID, ownerID,
+	).Scan(&parentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrFolderNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete folder: %w", err)
+	}
+
+	switch mode {
+	case FolderDeleteCascade:
+		_, err = tx.Exec(ctx, `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM folders WHERE id = $1
+			UNION ALL
+			SELECT f.id FROM folders f JOIN subtree s ON f.parent_id = s.id
+		)
+		UPDATE notes SET deleted_at = NOW()
+		WHERE author_id = $2 AND deleted_at IS NULL AND folder_id IN (SELECT id FROM subtree)
+		`, folderID, ownerID)
+		if err != nil {
+			return fmt.Errorf("failed to trash folder notes: %w", err)
+		}
+
+	case FolderDeleteMoveUp:
+		_, err = tx.Exec(ctx, `UPDATE folders SET parent_id = $2, updated_at = NOW() WHERE parent_id = $1`, folderID, parentID)
+		if err != nil {
+			return fmt.Errorf("failed to move sub-folders: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE notes SET folder_id = $2 WHERE folder_id = $1`, folderID, parentID)
+		if err != nil {
+			return fmt.Errorf("failed to move folder notes: %w", err)
+		}
+
+	default:
+		return fmt.Errorf("unknown folder delete mode %q", mode)
+	}
+
+	// sub-folders left over in cascade mode go with it through ON DELETE CASCADE,
+	// and their trashed notes fall back to the top level via ON DELETE SET NULL
+	if _, err := tx.Exec(ctx, `DELETE FROM folders WHERE id = $1`, folderID); err != nil {
+		return fmt.Errorf("failed to delete folder: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *postgresNotesRepository) MoveNote(ctx context.Context, ownerID, noteID string, folderID *string) error {
+	query := `
+	UPDATE notes
+	SET folder_id = $3
+	WHERE id = $1 AND author_id = $2 AND workspace_id IS NULL AND deleted_at IS NULL
+	  AND ($3::uuid IS NULL OR EXISTS (SELECT 1 FROM folders WHERE id = $3 AND owner_id = $2))
+	`
+
+	cmdTag, err := r.db.Exec(ctx, query, noteID, ownerID, folderID)
+	if err != nil {
+		return fmt.Errorf("failed to move note: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return fmt.Errorf("%w or %w", ErrNoteNotFound, ErrFolderNotFound)
+	}
+
+	return nil
+}
+
+func (r *postgresNotesRepository) ListTrash(ctx context.Context, authorID string) ([]*NoteSummary, error) {
+	query := `
+	SELECT n.id, n.title, n.author_id, n.public, n.slug, n.created_at, n.deleted_at
+	FROM notes n
+	WHERE n.deleted_at IS NOT NULL AND ` + accessCondition(PermOwn, 1, 0) + `
+	ORDER BY n.deleted_at DESC
+	`
+
+	rows, err := r.db.Query(ctx, query, authorID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trash: %w", err)
+	}
+	defer rows.Close()
+
+	var notes []*NoteSummary
+
+	for rows.Next() {
+		var n NoteSummary
+		err := rows.Scan(&n.ID, &n.Title, &n.AuthorID, &n.Public, &n.Slug, &n.CreatedAt, &n.DeletedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan note row: %w", err)
+		}
+		notes = append(notes, &n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return notes, nil
+}
+
+func (r *postgresNotesRepository) RestoreNote(ctx context.Context, noteID, authorID string) error {
+	query := `
+	UPDATE notes n SET deleted_at = NULL
+	WHERE n.id = $1 AND n.deleted_at IS NOT NULL AND ` + accessCondition(PermOwn, 2, 0)
+
+	cmdTag, err := r.db.Exec(ctx, query, noteID, authorID)
+	if err != nil {
+		return fmt.Errorf("failed to restore note: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return ErrNoteNotFound
+	}
+
+	return nil
+}
+
+// EmptyTrash permanently deletes the author's trashed personal notes. It
+// returns how many notes went and the blob keys of their attachments, which
+// the caller is responsible for removing from the blob store. Trashed
+// workspace notes are shared, so they are left to PurgeTrash.
+func (r *postgresNotesRepository) EmptyTrash(ctx context.Context, authorID string) (int64, []string, error) {
+	n, keys, err := r.hardDelete(ctx, `author_id = $1 AND workspace_id IS NULL AND deleted_at IS NOT NULL`, authorID)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to empty trash: %w", err)
+	}
+
+	return n, keys, nil
+}
+
+// PurgeTrash is EmptyTrash for every user, limited to notes trashed before
+// deletedBefore.
+func (r *postgresNotesRepository) PurgeTrash(ctx context.Context, deletedBefore time.Time) (int64, []string, error) {
+	n, keys, err := r.hardDelete(ctx, `deleted_at IS NOT NULL AND deleted_at < $1`, deletedBefore)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to purge trash: %w", err)
+	}
+
+	return n, keys, nil
+}
+
+// hardDelete removes the notes matching where and collects the storage keys
+// of their attachments in the same statement. The attachment rows are removed
+// by ON DELETE CASCADE, but the outer SELECT still reads them from the
+// statement's snapshot.
+func (r *postgresNotesRepository) hardDelete(ctx context.Context, where string, args ...any) (int64, []string, error) {
+	query := `
+	WITH purged AS (
+		DELETE FROM notes WHERE ` + where + ` RETURNING id
+	)
+	SELECT (SELECT COUNT(*) FROM purged),
+	       ARRAY(SELECT a.storage_key FROM attachments a WHERE a.note_id IN (SELECT id FROM purged))
+	`
+
+	var n int64
+	var keys []string
+	if err := r.db.QueryRow(ctx, query, args...).Scan(&n, &keys); err != nil {
+		return 0, nil, err
+	}
+
+	return n, keys, nil
+}
+
+// CanEditAttachments returns ErrNoteNotFound unless userID may add
+// attachments to the note, under the same condition CreateAttachment
+// applies, so uploads can be refused before anything is stored.
+func (r *postgresNotesRepository) CanEditAttachments(ctx context.Context, noteID, userID string) error {
+	var ok bool
+	err := r.db.QueryRow(ctx, `
+	SELECT EXISTS (
+		SELECT 1 FROM notes n
+		WHERE n.id = $1 AND n.deleted_at IS NULL AND `+accessCondition(PermEdit, 2, 0)+`
+	)
+	`, noteID, userID).Scan(&ok)
+	if err != nil {
+		return fmt.Errorf("failed to check note access: %w", err)
+	}
+
+	if !ok {
+		return ErrNoteNotFound
+	}
+
+	return nil
+}
+
+func (r *postgresNotesRepository) CreateAttachment(ctx context.Context, a *Attachment) (*Attachment, error) {
+	query := `
+	INSERT INTO attachments(note_id, uploader_id, filename, content_type, size, storage_key)
+	SELECT n.id, $2, $3, $4, $5, $6
+	FROM notes n
+	WHERE n.id = $1 AND n.deleted_at IS NULL AND ` + accessCondition(PermEdit, 2, 0) + `
+	RETURNING id, created_at
+	`
+
+	err := r.db.QueryRow(ctx, query,
+		a.NoteID,
+		a.UploaderID,
+		a.Filename,
+		a.ContentType,
+		a.Size,
+		a.StorageKey,
+	).Scan(&a.ID, &a.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoteNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save attachment: %w", err)
+	}
+
+	return a, nil
+}
+
+func (r *postgresNotesRepository) ListAttachments(ctx context.Context, noteID, authorID string) ([]*Attachment, error) {
+	query := `
+	SELECT a.id, a.note_id, a.uploader_id, a.filename, a.content_type, a.size, a.storage_key, a.created_at
+	FROM attachments a
+	JOIN notes n ON n.id = a.note_id
+	WHERE a.note_id = $1 AND n.deleted_at IS NULL AND ` + memberCondition(2, 0) + `
+	ORDER BY a.created_at
+	`
+
+	rows, err := r.db.Query(ctx, query, noteID, authorID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attachments: %w", err)
+	}
+	defer rows.Close()
+
+	attachments := []*Attachment{}
+
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return attachments, nil
+}
+
+// GetAttachment applies the same visibility rules as GetNoteBySlug to the
+// attachment's note: anonymous callers only reach public notes, signed-in
+// callers also reach their own notes, their workspaces' notes and notes shared
+// with their email.
+func (r *postgresNotesRepository) GetAttachment(ctx context.Context, attachmentID string, userID, emailID *string) (*Attachment, error) {
+	query := `
+	SELECT a.id, a.note_id, a.uploader_id, a.filename, a.content_type, a.size, a.storage_key, a.created_at,
+	       ` + lockColumn(0, 0) + `
+	FROM attachments a
+	JOIN notes n ON n.id = a.note_id
+	WHERE a.id = $1
+	  AND n.deleted_at IS NULL
+	  AND ` + accessCondition(PermRead, 0, 0)
+	args := []any{attachmentID}
+
+	if userID != nil {
+		query = `
+		SELECT a.id, a.note_id, a.uploader_id, a.filename, a.content_type, a.size, a.storage_key, a.created_at,
+		       ` + lockColumn(2, 3) + `
+		FROM attachments a
+		JOIN notes n ON n.id = a.note_id
+		WHERE a.id = $1
+		  AND n.deleted_at IS NULL
+		  AND ` + accessCondition(PermRead, 2, 3)
+		args = append(args, *userID, *emailID)
+	}
+
+	var lockHash *string
+
+	a, err := scanAttachment(r.db.QueryRow(ctx, query, args...), &lockHash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a.lockHash = lockHash
+	return a, nil
+}
+
+func (r *postgresNotesRepository) DeleteAttachment(ctx context.Context, attachmentID, authorID string) (*Attachment, error) {
+	query := `
+	DELETE FROM attachments a
+	USING notes n
+	WHERE a.id = $1 AND n.id = a.note_id AND ` + accessCondition(PermEdit, 2, 0) + `
+	RETURNING a.id, a.note_id, a.uploader_id, a.filename, a.content_type, a.size, a.storage_key, a.created_at
+	`
+
+	a, err := scanAttachment(r.db.QueryRow(ctx, query, attachmentID, authorID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// scanAttachment scans the attachment columns in table order, followed by
+// any extra columns the query selects into extra.
+func scanAttachment(row pgx.Row, extra ...any) (*Attachment, error) {
+	var a Attachment
+	dest := []any{
+		&a.ID,
+		&a.NoteID,
+		&a.UploaderID,
+		&a.Filename,
+		&a.ContentType,
+		&a.Size,
+		&a.StorageKey,
+		&a.CreatedAt,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan attachment row: %w", err)
+	}
+
+	return &a, nil
+}
@@ -0,0 +1,205 @@
+package notes
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/workspace"
+)
+
+// Role is what a caller may do with a note. The author owns a personal note;
+// a workspace note is instead reached through workspace membership, mapped by
+// workspaceNoteRoles. The other roles are also granted per email through
+// note_shares, to accounts that have verified that email, and anyone can
+// view a public note.
+type Role string
+
+const (
+	RoleOwner     Role = "owner"
+	RoleEditor    Role = "editor"
+	RoleCommenter Role = "commenter"
+	RoleViewer    Role = "viewer"
+)
+
+// Permission is an action checked against the caller's role on a note.
+type Permission int
+
+const (
+	PermRead Permission = iota
+	PermComment
+	PermEdit
+	// PermOwn covers deleting, sharing and the other owner-only actions.
+	PermOwn
+)
+
+// shareRoles lists the share roles granting each permission. This table and
+// the helpers below are the only place roles are interpreted: every query
+// that checks access builds its condition from accessCondition, so reading,
+// updating and deleting cannot drift apart.
+var shareRoles = map[Permission][]Role{
+	PermRead:    {RoleViewer, RoleCommenter, RoleEditor},
+	PermComment: {RoleCommenter, RoleEditor},
+	PermEdit:    {RoleEditor},
+	PermOwn:     nil,
+}
+
+// roleRank orders share roles from strongest to weakest.
+var roleRank = []Role{RoleEditor, RoleCommenter, RoleViewer}
+
+// noteRoleRank orders every note role from strongest to weakest.
+var noteRoleRank = []Role{RoleOwner, RoleEditor, RoleCommenter, RoleViewer}
+
+// workspaceNoteRoles is the role each workspace role has on the workspace's
+// notes.
+var workspaceNoteRoles = map[workspace.Role]Role{
+	workspace.RoleOwner:  RoleOwner,
+	workspace.RoleAdmin:  RoleOwner,
+	workspace.RoleMember: RoleEditor,
+	workspace.RoleGuest:  RoleViewer,
+}
+
+// grants reports whether a caller with role r may perform perm.
+func (r Role) grants(perm Permission) bool {
+	if r == RoleOwner {
+		return true
+	}
+	for _, role := range shareRoles[perm] {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// workspaceRoles lists the workspace roles granting perm on workspace notes.
+func workspaceRoles(perm Permission) []string {
+	var roles []string
+	for _, wr := range workspace.Roles {
+		if workspaceNoteRoles[wr].grants(perm) {
+			roles = append(roles, string(wr))
+		}
+	}
+	return roles
+}
+
+// IsShareRole reports whether r can be granted through a share.
+func (r Role) IsShareRole() bool {
+	for _, role := range roleRank {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// accessCondition returns a SQL predicate on the note aliased n that holds
+// when the caller may perform perm. userParam and emailParam are the
+// positions of the caller's id and email among the query args; pass 0 for an
+// anonymous caller.
+func accessCondition(perm Permission, userParam, emailParam int) string {
+	var clauses []string
+
+	if perm == PermRead {
+		clauses = append(clauses, "n.public = TRUE")
+	}
+
+	return orClauses(append(clauses, grantClauses(perm, userParam, emailParam)...))
+}
+
+// memberCondition is accessCondition for reading without the public clause:
+// it holds only for the owner, workspace members and the people the note is
+// shared with.
+func memberCondition(userParam, emailParam int) string {
+	return orClauses(grantClauses(PermRead, userParam, emailParam))
+}
+
+func grantClauses(perm Permission, userParam, emailParam int) []string {
+	var clauses []string
+
+	if userParam > 0 {
+		clauses = append(clauses,
+			fmt.Sprintf("(n.workspace_id IS NULL AND n.author_id = $%d)", userParam),
+			fmt.Sprintf(
+				"EXISTS (SELECT 1 FROM workspace_members wm WHERE wm.workspace_id = n.workspace_id AND wm.user_id = $%d AND wm.role IN (%s))",
+				userParam, sqlStringList(workspaceRoles(perm)),
+			),
+		)
+	}
+
+	if roles := shareRoles[perm]; len(roles) > 0 && emailParam > 0 {
+		clauses = append(clauses, fmt.Sprintf(
+			"(%s AND EXISTS (SELECT 1 FROM note_shares ns WHERE ns.note_id = n.id AND ns.email = $%d AND ns.role IN (%s)))",
+			verifiedEmail(emailParam), emailParam, sqlRoleList(roles),
+		))
+	}
+
+	return clauses
+}
+
+// verifiedEmail returns a SQL predicate that holds when the email at
+// position emailParam belongs to an account that has verified it. Shares are
+// granted by email, so they only count for the account proven to own it;
+// otherwise anyone could register an address and read what was shared with
+// it.
+func verifiedEmail(emailParam int) string {
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM users vu WHERE vu.email = $%d AND vu.email_verified)", emailParam)
+}
+
+func orClauses(clauses []string) string {
+	if len(clauses) == 0 {
+		return "FALSE"
+	}
+
+	return "(" + strings.Join(clauses, " OR ") + ")"
+}
+
+// roleColumn returns a SQL expression for the caller's role on the note
+// aliased n, or NULL when the caller has none. When several grants apply the
+// strongest wins. Parameters are as for accessCondition.
+func roleColumn(userParam, emailParam int) string {
+	candidates := []string{"CASE WHEN n.public THEN 'viewer' END"}
+
+	if emailParam > 0 {
+		candidates = append(candidates, fmt.Sprintf(
+			"(SELECT ns.role FROM note_shares ns WHERE ns.note_id = n.id AND ns.email = $%d AND %s)",
+			emailParam, verifiedEmail(emailParam),
+		))
+	}
+
+	if userParam > 0 {
+		mapped := "CASE wm.role"
+		for _, wr := range workspace.Roles {
+			mapped += fmt.Sprintf(" WHEN '%s' THEN '%s'", wr, workspaceNoteRoles[wr])
+		}
+		mapped += " END"
+
+		candidates = append(candidates,
+			fmt.Sprintf("CASE WHEN n.workspace_id IS NULL AND n.author_id = $%d THEN 'owner' END", userParam),
+			fmt.Sprintf(
+				"(SELECT %s FROM workspace_members wm WHERE wm.workspace_id = n.workspace_id AND wm.user_id = $%d)",
+				mapped, userParam,
+			),
+		)
+	}
+
+	return fmt.Sprintf(
+		"(SELECT c.role FROM (VALUES (%s)) AS c(role) WHERE c.role IS NOT NULL ORDER BY array_position(ARRAY[%s], c.role) LIMIT 1)",
+		strings.Join(candidates, "), ("), sqlRoleList(noteRoleRank),
+	)
+}
+
+func sqlRoleList(roles []Role) string {
+	names := make([]string, len(roles))
+	for i, r := range roles {
+		names[i] = string(r)
+	}
+	return sqlStringList(names)
+}
+
+func sqlStringList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = "'" + v + "'"
+	}
+	return strings.Join(quoted, ", ")
+}
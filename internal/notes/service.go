@@ -1,12 +1,30 @@
 package notes
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
 	//"crypto/sha1"
-	//"encoding/hex"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"time"
+
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/mail"
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/ratelimit"
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/storage"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // service is a private struct that implements the NotesService interface.
@@ -15,7 +33,18 @@ import (
 //
 // In short: service = business logic + repository access.
 type service struct {
-	repo NotesRepository
+	repo     NotesRepository
+	markdown *markdownRenderer
+	blobs    storage.BlobStore
+	limits   AttachmentLimits
+
+	mailer mail.Mailer
+	appURL string
+	views  *ViewRecorder
+
+	unlocks        *unlockSigner
+	clientAttempts *ratelimit.Limiter
+	noteAttempts   *ratelimit.Limiter
 }
 
 // NewNotesService is a public constructor function that returns a NotesService implementation.
@@ -28,8 +57,26 @@ type service struct {
 //
 // Returning the interface (NotesService) instead of the concrete type (service)
 // hides implementation details and allows easy swapping or mocking in tests.
-func NewNotesService(r NotesRepository) NotesService {
-	return &service{repo: r}
+//
+// Attachment bytes go to the injected BlobStore and are checked against limits.
+// Share notifications go through mailer, with links built on appURL; a nil
+// mailer turns them off. Public reads are counted through views, or not at
+// all when it is nil.
+func NewNotesService(r NotesRepository, blobs storage.BlobStore, limits AttachmentLimits, mailer mail.Mailer, appURL string, views *ViewRecorder) NotesService {
+	return &service{
+		repo:     r,
+		markdown: newMarkdownRenderer(),
+		blobs:    blobs,
+		limits:   limits,
+
+		mailer: mailer,
+		appURL: strings.TrimSuffix(appURL, "/"),
+		views:  views,
+
+		unlocks:        newUnlockSigner(os.Getenv("JWT_SECRET")),
+		clientAttempts: ratelimit.New(clientUnlockAttempts, unlockAttemptWindow),
+		noteAttempts:   ratelimit.New(noteUnlockAttempts, unlockAttemptWindow),
+	}
 }
 
 func (s *service) CreateNote(ctx context.Context, n *Note) (*Note, error) {
@@ -46,6 +93,19 @@ func (s *service) CreateNote(ctx context.Context, n *Note) (*Note, error) {
 		return nil, fmt.Errorf("missing title")
 	}
 
+	// folders are personal, so they cannot hold a workspace's notes
+	if n.WorkspaceID != nil && n.FolderID != nil {
+		return nil, ErrWorkspaceFolder
+	}
+
+	if n.Tags != nil {
+		tags, err := normalizeTags(n.Tags)
+		if err != nil {
+			return nil, err
+		}
+		n.Tags = tags
+	}
+
 	now := time.Now()
 
 	n.CreatedAt = now
@@ -61,12 +121,25 @@ func (s *service) CreateNote(ctx context.Context, n *Note) (*Note, error) {
 
 }
 
-func (s *service) GetUserNotes(ctx context.Context, userID string) ([]*NoteSummary, error) {
+func (s *service) GetUserNotes(ctx context.Context, userID string, filter NoteFilter, page PageRequest) (*NotePage, error) {
 	if userID == "" {
 		return nil, fmt.Errorf("userID is required")
 	}
 
-	notes, err := s.repo.GetNotesByAuthor(ctx, userID)
+	if filter.Tags != nil {
+		tags, err := normalizeTags(filter.Tags)
+		if err != nil {
+			return nil, err
+		}
+		filter.Tags = tags
+	}
+
+	page, err := normalizePage(page)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := s.repo.GetNotesByAuthor(ctx, userID, filter, page)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch notes: %w", err)
 	}
@@ -102,9 +175,11 @@ func (s *service) DeleteNote(ctx context.Context, noteID, userID string) error {
 	return nil
 }
 
-func (s *service) UpdateNote(ctx context.Context, n *Note) (*NoteSummary, error) {
+// UpdateNote replaces a note's title, content and visibility. The caller
+// must be the owner or hold an editor share; the repository enforces it.
+func (s *service) UpdateNote(ctx context.Context, caller Caller, n *Note) (*NoteSummary, error) {
 
-	if n.AuthorID == "" {
+	if caller.UserID == "" {
 		return nil, fmt.Errorf("missing authour id")
 	}
 
@@ -116,15 +191,60 @@ func (s *service) UpdateNote(ctx context.Context, n *Note) (*NoteSummary, error)
 		return nil, fmt.Errorf("missing title")
 	}
 
-	noteSummary, err := s.repo.UpdateNote(ctx, n)
+	if n.Tags != nil {
+		tags, err := normalizeTags(n.Tags)
+		if err != nil {
+			return nil, err
+		}
+		n.Tags = tags
+	}
+
+	noteSummary, err := s.repo.UpdateNote(ctx, n, caller)
+
+	if err != nil {
+		return nil, err
+	}
+	return noteSummary, nil
+}
+
+func (s *service) PatchNote(ctx context.Context, noteID string, caller Caller, patch NotePatch) (*NoteSummary, error) {
+	if caller.UserID == "" {
+		return nil, fmt.Errorf("missing authour id")
+	}
+
+	if noteID == "" {
+		return nil, fmt.Errorf("missing note id")
+	}
+
+	// an empty patch would still bump the version and record a revision
+	if patch.Title == nil && patch.Content == nil && patch.Public == nil && patch.Tags == nil {
+		return nil, ErrEmptyPatch
+	}
+
+	if patch.Title != nil && *patch.Title == "" {
+		return nil, ErrEmptyTitle
+	}
+
+	if patch.Content != nil && *patch.Content == "" {
+		return nil, ErrEmptyContent
+	}
+
+	if patch.Tags != nil {
+		tags, err := normalizeTags(patch.Tags)
+		if err != nil {
+			return nil, err
+		}
+		patch.Tags = tags
+	}
 
+	noteSummary, err := s.repo.PatchNote(ctx, noteID, caller, patch)
 	if err != nil {
 		return nil, err
 	}
 	return noteSummary, nil
 }
 
-func (s *service) ShareNoteViaEmail(ctx context.Context, notesId, ownerid, email string) error {
+func (s *service) ShareNoteViaEmail(ctx context.Context, notesId, ownerid, email string, role Role) error {
 
 	if ownerid == "" {
 		return fmt.Errorf("unauthrozied access attempt")
@@ -138,12 +258,22 @@ func (s *service) ShareNoteViaEmail(ctx context.Context, notesId, ownerid, email
 		return fmt.Errorf("empty")
 	}
 
-	err := s.repo.AddEmailShare(ctx, notesId, ownerid, email)
+	if role == "" {
+		role = RoleViewer
+	}
+
+	if !role.IsShareRole() {
+		return ErrInvalidRole
+	}
+
+	err := s.repo.AddEmailShare(ctx, notesId, ownerid, email, role)
 
 	if err != nil {
 		return fmt.Errorf("error %w", err)
 	}
 
+	s.notifyShares(ctx, notesId, []Collaborator{{Email: email, Role: role}}, nil)
+
 	return nil
 }
 
@@ -167,20 +297,972 @@ func (s *service) RevokeEmailAccess(ctx context.Context, noteID, ownerID, email
 		return fmt.Errorf("error %w", err)
 	}
 
+	s.notifyShares(ctx, noteID, nil, []string{email})
+
+	return nil
+}
+
+func (s *service) CreateShareLink(ctx context.Context, noteID, ownerID string, expiresAt *time.Time, maxViews *int) (*ShareLink, error) {
+	if ownerID == "" {
+		return nil, fmt.Errorf("unauthrozied access attempt")
+	}
+
+	if noteID == "" {
+		return nil, fmt.Errorf("noteID is required")
+	}
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("expires_at must be in the future")
+	}
+
+	if maxViews != nil && *maxViews < 1 {
+		return nil, fmt.Errorf("max_views must be at least 1")
+	}
+
+	token, err := newSecretToken()
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := s.repo.CreateShareLink(ctx, &ShareLink{
+		NoteID:    noteID,
+		ExpiresAt: expiresAt,
+		MaxViews:  maxViews,
+	}, ownerID, hashToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("error while creating share link: %w", err)
+	}
+
+	// the only time the raw token leaves the server
+	link.Token = token
+	return link, nil
+}
+
+func (s *service) ListShareLinks(ctx context.Context, noteID, ownerID string) ([]*ShareLink, error) {
+	if ownerID == "" {
+		return nil, fmt.Errorf("unauthrozied access attempt")
+	}
+
+	links, err := s.repo.ListShareLinks(ctx, noteID, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing share links: %w", err)
+	}
+
+	return links, nil
+}
+
+func (s *service) RevokeShareLink(ctx context.Context, linkID, ownerID string) error {
+	if ownerID == "" {
+		return fmt.Errorf("unauthrozied access attempt")
+	}
+
+	if err := s.repo.RevokeShareLink(ctx, linkID, ownerID); err != nil {
+		return fmt.Errorf("error while revoking share link: %w", err)
+	}
+
 	return nil
 }
 
-func (s *service) GetPublicNote(ctx context.Context, slug string, userId, emailId *string)(*Note, error){
+func (s *service) GetNoteByShareLink(ctx context.Context, token string) (*Note, error) {
+	if token == "" {
+		return nil, ErrShareLinkNotFound
+	}
+
+	note, err := s.repo.GetNoteByShareLink(ctx, hashToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("error %w", err)
+	}
+
+	return note, nil
+}
+
+func (s *service) TransferNote(ctx context.Context, noteID, ownerID, toEmail string, keepEditor bool) (*NoteTransfer, error) {
+	if ownerID == "" {
+		return nil, fmt.Errorf("unauthrozied access attempt")
+	}
+
+	if noteID == "" {
+		return nil, fmt.Errorf("noteID is required")
+	}
+
+	toEmail = strings.TrimSpace(toEmail)
+	if toEmail == "" {
+		return nil, fmt.Errorf("empty email cant be provided")
+	}
+
+	transfers, err := s.repo.ProposeTransfers(ctx, ownerID, toEmail, []string{noteID}, keepEditor)
+	if err != nil {
+		return nil, fmt.Errorf("error while proposing transfer: %w", err)
+	}
+
+	s.notifyTransfer(ctx, transfers)
+
+	return transfers[0], nil
+}
+
+// TransferAllNotes proposes handing every live personal note of ownerID to
+// toEmail as one batch. Notes already offered to someone are left out.
+func (s *service) TransferAllNotes(ctx context.Context, ownerID, toEmail string, keepEditor bool) ([]*NoteTransfer, error) {
+	if ownerID == "" {
+		return nil, fmt.Errorf("unauthrozied access attempt")
+	}
+
+	toEmail = strings.TrimSpace(toEmail)
+	if toEmail == "" {
+		return nil, fmt.Errorf("empty email cant be provided")
+	}
+
+	transfers, err := s.repo.ProposeTransfers(ctx, ownerID, toEmail, nil, keepEditor)
+	if err != nil {
+		return nil, fmt.Errorf("error while proposing transfer: %w", err)
+	}
+
+	s.notifyTransfer(ctx, transfers)
+
+	return transfers, nil
+}
+
+func (s *service) ListTransfers(ctx context.Context, userID string) (*TransferList, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("userID is required")
+	}
+
+	transfers, err := s.repo.ListTransfers(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing transfers: %w", err)
+	}
+
+	return transfers, nil
+}
+
+// AcceptTransfer takes ownership of the note in transfer id, or of every note
+// in batch id when batch is set, and returns how many notes changed hands.
+func (s *service) AcceptTransfer(ctx context.Context, userID, id string, batch bool) (int, error) {
+	if userID == "" {
+		return 0, fmt.Errorf("unauthrozied access attempt")
+	}
+
+	if id == "" {
+		return 0, fmt.Errorf("transfer id is required")
+	}
+
+	moved, err := s.repo.AcceptTransfers(ctx, userID, id, batch)
+	if err != nil {
+		return 0, fmt.Errorf("error while accepting transfer: %w", err)
+	}
+
+	return len(moved), nil
+}
+
+func (s *service) CancelTransfer(ctx context.Context, userID, id string, batch bool) (int64, error) {
+	if userID == "" {
+		return 0, fmt.Errorf("unauthrozied access attempt")
+	}
+
+	if id == "" {
+		return 0, fmt.Errorf("transfer id is required")
+	}
+
+	count, err := s.repo.CancelTransfers(ctx, userID, id, batch)
+	if err != nil {
+		return 0, fmt.Errorf("error while cancelling transfer: %w", err)
+	}
+
+	return count, nil
+}
+
+func (s *service) GetPublicNote(ctx context.Context, slug string, userId, emailId *string, unlock NoteUnlock)(*Note, error){
 
 	note, err := s.repo.GetNoteBySlug(ctx,slug, userId, emailId)
 
+	if errors.Is(err, ErrNoteNotFound) {
+		// a slug the note has since given up still finds it
+		if current, herr := s.repo.GetSlugRedirect(ctx, slug, userId, emailId); herr == nil {
+			return nil, &SlugMovedError{Slug: current}
+		}
+	}
+
 	if err != nil {
 		return nil, fmt.Errorf("error %w", err)
 	}
 
+	if note.lockHash != nil {
+		token, err := s.unlockNote(note.ID, *note.lockHash, unlock)
+		if err != nil {
+			return nil, err
+		}
+		note.UnlockToken = token
+	}
+
 	return note,nil
 }
 
+// RecordView counts a read of a note. It never blocks the reader: the view
+// is handed to the recorder and written later.
+func (s *service) RecordView(view NoteView) {
+	if s.views == nil || view.NoteID == "" {
+		return
+	}
+
+	if view.ViewedAt.IsZero() {
+		view.ViewedAt = time.Now()
+	}
+
+	s.views.Record(view)
+}
+
+// statsIntervals are the bucket sizes GetViewStats accepts, with their
+// approximate length for capping the number of buckets.
+var statsIntervals = map[string]time.Duration{
+	"hour":  time.Hour,
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+}
+
+const maxStatsBuckets = 1000
+
+// GetViewStats returns view counts for a note the caller owns. A zero to
+// means now, a zero from 30 days before to, and an empty interval "day".
+func (s *service) GetViewStats(ctx context.Context, noteID, ownerID string, from, to time.Time, interval string) (*ViewStats, error) {
+	if ownerID == "" {
+		return nil, fmt.Errorf("unauthrozied access attempt")
+	}
+
+	if noteID == "" {
+		return nil, fmt.Errorf("noteID is required")
+	}
+
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, 0, -30)
+	}
+	if interval == "" {
+		interval = "day"
+	}
+
+	step, ok := statsIntervals[interval]
+	if !ok || !from.Before(to) || to.Sub(from)/step >= maxStatsBuckets {
+		return nil, ErrInvalidStatsRange
+	}
+
+	stats, err := s.repo.GetViewStats(ctx, noteID, ownerID, from, to, interval)
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching view stats: %w", err)
+	}
+
+	return stats, nil
+}
+
+// SetNoteSlug changes the slug of a note. An empty slug asks for one derived
+// from the current title; anything else must be a valid custom slug.
+func (s *service) SetNoteSlug(ctx context.Context, noteID, ownerID, slug string) (string, error) {
+	if ownerID == "" {
+		return "", fmt.Errorf("unauthrozied access attempt")
+	}
+
+	if noteID == "" {
+		return "", fmt.Errorf("noteID is required")
+	}
+
+	slug = strings.ToLower(strings.TrimSpace(slug))
+	if slug != "" && (len(slug) < 3 || len(slug) > 100 || !customSlugPattern.MatchString(slug)) {
+		return "", ErrInvalidSlug
+	}
+
+	slug, err := s.repo.SetNoteSlug(ctx, noteID, ownerID, slug)
+	if err != nil {
+		return "", fmt.Errorf("error while setting slug: %w", err)
+	}
+
+	return slug, nil
+}
+
+func (s *service) ListCollaborators(ctx context.Context, noteID, ownerID string) ([]*Collaborator, error) {
+	if ownerID == "" {
+		return nil, fmt.Errorf("unauthrozied access attempt")
+	}
+
+	collaborators, err := s.repo.ListCollaborators(ctx, noteID, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing collaborators: %w", err)
+	}
+
+	return collaborators, nil
+}
+
+func (s *service) UpdateCollaborators(ctx context.Context, noteID, ownerID string, add []Collaborator, remove []string) ([]*Collaborator, error) {
+	if ownerID == "" {
+		return nil, fmt.Errorf("unauthrozied access attempt")
+	}
+
+	if noteID == "" {
+		return nil, fmt.Errorf("noteID is required")
+	}
+
+	removing := make(map[string]bool, len(remove))
+	for i, email := range remove {
+		email = strings.TrimSpace(email)
+		if email == "" {
+			return nil, fmt.Errorf("empty email cant be provided")
+		}
+		if removing[email] {
+			return nil, fmt.Errorf("%w: %s", ErrShareNotFound, email)
+		}
+		removing[email] = true
+		remove[i] = email
+	}
+
+	adding := make(map[string]bool, len(add))
+	for i := range add {
+		c := &add[i]
+		c.Email = strings.TrimSpace(c.Email)
+		if c.Email == "" {
+			return nil, fmt.Errorf("empty email cant be provided")
+		}
+		if adding[c.Email] {
+			return nil, fmt.Errorf("%w: %s", ErrShareExists, c.Email)
+		}
+		adding[c.Email] = true
+
+		if c.Role == "" {
+			c.Role = RoleViewer
+		}
+		if !c.Role.IsShareRole() {
+			return nil, ErrInvalidRole
+		}
+	}
+
+	collaborators, err := s.repo.UpdateCollaborators(ctx, noteID, ownerID, add, remove)
+	if err != nil {
+		return nil, fmt.Errorf("error while updating collaborators: %w", err)
+	}
+
+	// an email both removed and re-added only had its role changed
+	var revoked []string
+	for _, email := range remove {
+		if !adding[email] {
+			revoked = append(revoked, email)
+		}
+	}
+	s.notifyShares(ctx, noteID, add, revoked)
+
+	return collaborators, nil
+}
+
+func (s *service) GetSharedWithMe(ctx context.Context, email string, page PageRequest) (*SharedNotePage, error) {
+	if email == "" {
+		return nil, fmt.Errorf("email is required")
+	}
+
+	page, err := normalizePage(page)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := s.repo.GetNotesSharedWith(ctx, email, page)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch shared notes: %w", err)
+	}
+
+	return notes, nil
+}
+
+func (s *service) SetNotePassword(ctx context.Context, noteID, ownerID, password string) error {
+	if ownerID == "" {
+		return fmt.Errorf("unauthrozied access attempt")
+	}
+
+	if noteID == "" {
+		return fmt.Errorf("noteID is required")
+	}
+
+	// an empty password removes the protection
+	var hash *string
+
+	if password != "" {
+		if len(password) < minNotePasswordLen {
+			return ErrWeakPassword
+		}
+
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("error while hashing password: %w", err)
+		}
+
+		h := string(hashed)
+		hash = &h
+	}
+
+	if err := s.repo.SetNotePassword(ctx, noteID, ownerID, hash); err != nil {
+		return fmt.Errorf("error while setting note password: %w", err)
+	}
+
+	return nil
+}
+
+// unlockNote lets a reader past a note password. Any valid unlock token is
+// enough; otherwise the passphrase is checked, subject to throttling, and a
+// fresh token is returned for the reader to keep.
+func (s *service) unlockNote(noteID, passwordHash string, unlock NoteUnlock) (string, error) {
+	for _, token := range unlock.Tokens {
+		if s.unlocks.valid(token, noteID, passwordHash) {
+			return "", nil
+		}
+	}
+
+	if unlock.Password == "" {
+		return "", ErrNoteLocked
+	}
+
+	clientKey := noteID + "|" + unlock.Client
+
+	if wait := s.clientAttempts.RetryAfter(clientKey); wait > 0 {
+		return "", &TooManyAttemptsError{RetryAfter: wait}
+	}
+
+	// The per-note cap never turns a correct passphrase away, or anyone
+	// could lock readers out by guessing. Past it every attempt is slowed
+	// down instead, and wrong ones are told to back off.
+	noteWait := s.noteAttempts.RetryAfter(noteID)
+	if noteWait > 0 {
+		time.Sleep(noteUnlockDelay)
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(unlock.Password)) != nil {
+		s.clientAttempts.Fail(clientKey)
+		s.noteAttempts.Fail(noteID)
+		if noteWait > 0 {
+			return "", &TooManyAttemptsError{RetryAfter: noteWait}
+		}
+		return "", ErrWrongPassword
+	}
+
+	s.clientAttempts.Clear(clientKey)
+
+	token, err := s.unlocks.issue(noteID, passwordHash)
+	if err != nil {
+		return "", fmt.Errorf("error while issuing unlock token: %w", err)
+	}
+
+	return token, nil
+}
+
+// RenderHTML renders the note's Markdown content to sanitized HTML.
+func (s *service) RenderHTML(n *Note) (string, error) {
+	return s.markdown.Render(n)
+}
+
+func (s *service) ListRevisions(ctx context.Context, noteID, userID string) ([]*RevisionSummary, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("userID is required")
+	}
+
+	if noteID == "" {
+		return nil, fmt.Errorf("noteID is required")
+	}
+
+	revisions, err := s.repo.ListRevisions(ctx, noteID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing revisions: %w", err)
+	}
+
+	return revisions, nil
+}
+
+func (s *service) GetRevision(ctx context.Context, noteID, userID string, revision int) (*NoteRevision, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("userID is required")
+	}
+
+	if noteID == "" || revision < 1 {
+		return nil, fmt.Errorf("noteID and a positive revision are required")
+	}
+
+	rv, err := s.repo.GetRevision(ctx, noteID, userID, revision)
+	if err != nil {
+		return nil, fmt.Errorf("error while fetching revision: %w", err)
+	}
+
+	return rv, nil
+}
+
+func (s *service) DiffRevisions(ctx context.Context, noteID, userID string, from, to int) (*RevisionDiff, error) {
+	older, err := s.GetRevision(ctx, noteID, userID, from)
+	if err != nil {
+		return nil, err
+	}
+
+	newer, err := s.GetRevision(ctx, noteID, userID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RevisionDiff{
+		NoteID:    noteID,
+		From:      from,
+		To:        to,
+		FromTitle: older.Title,
+		ToTitle:   newer.Title,
+		Diff: unifiedDiff(
+			fmt.Sprintf("revision %d", from),
+			fmt.Sprintf("revision %d", to),
+			older.Content,
+			newer.Content,
+		),
+	}, nil
+}
+
+func (s *service) RestoreRevision(ctx context.Context, noteID, userID string, revision int) (*NoteSummary, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("userID is required")
+	}
+
+	if noteID == "" || revision < 1 {
+		return nil, fmt.Errorf("noteID and a positive revision are required")
+	}
+
+	summary, err := s.repo.RestoreRevision(ctx, noteID, userID, revision)
+	if err != nil {
+		return nil, fmt.Errorf("error while restoring revision: %w", err)
+	}
+
+	return summary, nil
+}
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+func (s *service) Search(ctx context.Context, userID, email, query string, limit int) ([]*SearchResult, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("userID is required")
+	}
+
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, fmt.Errorf("search query is required")
+	}
+
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	limit = min(limit, maxSearchLimit)
+
+	results, err := s.repo.Search(ctx, userID, email, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error while searching notes: %w", err)
+	}
+
+	return results, nil
+}
+
+func (s *service) ListTags(ctx context.Context, ownerID string) ([]*TagCount, error) {
+	if ownerID == "" {
+		return nil, fmt.Errorf("userID is required")
+	}
+
+	tags, err := s.repo.ListTags(ctx, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing tags: %w", err)
+	}
+
+	return tags, nil
+}
+
+func (s *service) RenameTag(ctx context.Context, ownerID, from, to string) error {
+	if ownerID == "" {
+		return fmt.Errorf("userID is required")
+	}
+
+	names, err := normalizeTags([]string{from, to})
+	if err != nil {
+		return err
+	}
+
+	// both names normalize to the same tag, nothing to do
+	if len(names) == 1 {
+		return nil
+	}
+
+	if err := s.repo.RenameTag(ctx, ownerID, names[0], names[1]); err != nil {
+		return fmt.Errorf("error while renaming tag: %w", err)
+	}
+
+	return nil
+}
+
+func (s *service) MergeTags(ctx context.Context, ownerID string, sources []string, target string) error {
+	if ownerID == "" {
+		return fmt.Errorf("userID is required")
+	}
+
+	sources, err := normalizeTags(sources)
+	if err != nil {
+		return err
+	}
+
+	targets, err := normalizeTags([]string{target})
+	if err != nil {
+		return err
+	}
+
+	if len(sources) == 0 {
+		return fmt.Errorf("at least one source tag is required")
+	}
+
+	if err := s.repo.MergeTags(ctx, ownerID, sources, targets[0]); err != nil {
+		return fmt.Errorf("error while merging tags: %w", err)
+	}
+
+	return nil
+}
+
+func (s *service) CreateFolder(ctx context.Context, f *Folder) (*Folder, error) {
+	if f.OwnerID == "" {
+		return nil, fmt.Errorf("userID is required")
+	}
+
+	f.Name = strings.TrimSpace(f.Name)
+	if f.Name == "" || len(f.Name) > 100 {
+		return nil, fmt.Errorf("folder name must be 1-100 characters")
+	}
+
+	folder, err := s.repo.CreateFolder(ctx, f)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating folder: %w", err)
+	}
+
+	return folder, nil
+}
+
+// GetFolderTree returns the owner's top-level folders with their
+// sub-folders nested under Children.
+func (s *service) GetFolderTree(ctx context.Context, ownerID string) ([]*Folder, error) {
+	if ownerID == "" {
+		return nil, fmt.Errorf("userID is required")
+	}
+
+	folders, err := s.repo.ListFolders(ctx, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing folders: %w", err)
+	}
+
+	byID := make(map[string]*Folder, len(folders))
+	for _, f := range folders {
+		byID[f.ID] = f
+	}
+
+	roots := []*Folder{}
+	for _, f := range folders {
+		if f.ParentID == nil {
+			roots = append(roots, f)
+			continue
+		}
+		if parent, ok := byID[*f.ParentID]; ok {
+			parent.Children = append(parent.Children, f)
+		}
+	}
+
+	return roots, nil
+}
+
+func (s *service) MoveFolder(ctx context.Context, ownerID, folderID string, parentID *string) error {
+	if ownerID == "" {
+		return fmt.Errorf("userID is required")
+	}
+
+	if folderID == "" {
+		return fmt.Errorf("folderID is required")
+	}
+
+	if parentID != nil && *parentID == folderID {
+		return ErrFolderCycle
+	}
+
+	if err := s.repo.MoveFolder(ctx, ownerID, folderID, parentID); err != nil {
+		return fmt.Errorf("error while moving folder: %w", err)
+	}
+
+	return nil
+}
+
+func (s *service) DeleteFolder(ctx context.Context, ownerID, folderID string, mode FolderDeleteMode) error {
+	if ownerID == "" {
+		return fmt.Errorf("userID is required")
+	}
+
+	if folderID == "" {
+		return fmt.Errorf("folderID is required")
+	}
+
+	// keeping the contents is the safe default
+	if mode == "" {
+		mode = FolderDeleteMoveUp
+	}
+
+	if mode != FolderDeleteCascade && mode != FolderDeleteMoveUp {
+		return fmt.Errorf("mode must be %q or %q", FolderDeleteCascade, FolderDeleteMoveUp)
+	}
+
+	if err := s.repo.DeleteFolder(ctx, ownerID, folderID, mode); err != nil {
+		return fmt.Errorf("error while deleting folder: %w", err)
+	}
+
+	return nil
+}
+
+func (s *service) MoveNote(ctx context.Context, ownerID, noteID string, folderID *string) error {
+	if ownerID == "" {
+		return fmt.Errorf("userID is required")
+	}
+
+	if noteID == "" {
+		return fmt.Errorf("noteID is required")
+	}
+
+	if err := s.repo.MoveNote(ctx, ownerID, noteID, folderID); err != nil {
+		return fmt.Errorf("error while moving note: %w", err)
+	}
+
+	return nil
+}
+
+func (s *service) ListTrash(ctx context.Context, userID string) ([]*NoteSummary, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("userID is required")
+	}
+
+	notes, err := s.repo.ListTrash(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing trash: %w", err)
+	}
+
+	return notes, nil
+}
+
+func (s *service) RestoreNote(ctx context.Context, noteID, userID string) error {
+	if userID == "" {
+		return fmt.Errorf("userID is required")
+	}
+
+	if noteID == "" {
+		return fmt.Errorf("noteID is required")
+	}
+
+	if err := s.repo.RestoreNote(ctx, noteID, userID); err != nil {
+		return fmt.Errorf("error while restoring note: %w", err)
+	}
+
+	return nil
+}
+
+func (s *service) EmptyTrash(ctx context.Context, userID string) (int64, error) {
+	if userID == "" {
+		return 0, fmt.Errorf("userID is required")
+	}
+
+	purged, keys, err := s.repo.EmptyTrash(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("error while emptying trash: %w", err)
+	}
+
+	deleteBlobs(ctx, s.blobs, keys)
+
+	return purged, nil
+}
+
+func (s *service) AddAttachment(ctx context.Context, noteID, userID, filename string, body io.Reader) (*Attachment, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("userID is required")
+	}
+
+	if noteID == "" {
+		return nil, fmt.Errorf("noteID is required")
+	}
+
+	// fail before storing anything if the caller cannot write to the note
+	if err := s.repo.CanEditAttachments(ctx, noteID, userID); err != nil {
+		return nil, fmt.Errorf("error while saving attachment: %w", err)
+	}
+
+	// sniff the real type from the first bytes instead of trusting the client
+	head := make([]byte, 512)
+	n, err := io.ReadFull(body, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	head = head[:n]
+
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if !slices.Contains(s.limits.AllowedTypes, contentType) {
+		return nil, fmt.Errorf("%w: %s", ErrAttachmentType, contentType)
+	}
+
+	key, err := newBlobKey(noteID)
+	if err != nil {
+		return nil, err
+	}
+
+	// read one byte past the limit so an oversized upload is detectable
+	content := io.LimitReader(io.MultiReader(bytes.NewReader(head), body), s.limits.MaxSize+1)
+
+	size, err := s.blobs.Put(ctx, key, content)
+	if err != nil {
+		s.blobs.Delete(ctx, key)
+		return nil, fmt.Errorf("failed to store attachment: %w", err)
+	}
+
+	if size > s.limits.MaxSize {
+		s.blobs.Delete(ctx, key)
+		return nil, ErrAttachmentTooLarge
+	}
+
+	attachment, err := s.repo.CreateAttachment(ctx, &Attachment{
+		NoteID:      noteID,
+		UploaderID:  userID,
+		Filename:    cleanFilename(filename),
+		ContentType: contentType,
+		Size:        size,
+		StorageKey:  key,
+	})
+	if err != nil {
+		s.blobs.Delete(ctx, key)
+		return nil, fmt.Errorf("error while saving attachment: %w", err)
+	}
+
+	return attachment, nil
+}
+
+func (s *service) ListAttachments(ctx context.Context, noteID, userID string) ([]*Attachment, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("userID is required")
+	}
+
+	attachments, err := s.repo.ListAttachments(ctx, noteID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing attachments: %w", err)
+	}
+
+	return attachments, nil
+}
+
+// OpenAttachment returns the attachment and a reader over its bytes, if the
+// caller may read the note it belongs to. The caller must close the reader.
+func (s *service) OpenAttachment(ctx context.Context, attachmentID string, userID, emailID *string, unlock NoteUnlock) (*Attachment, io.ReadCloser, error) {
+	attachment, err := s.repo.GetAttachment(ctx, attachmentID, userID, emailID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while fetching attachment: %w", err)
+	}
+
+	// a locked note's attachments need the same unlock as the note itself
+	if attachment.lockHash != nil {
+		if _, err := s.unlockNote(attachment.NoteID, *attachment.lockHash, unlock); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	body, err := s.blobs.Get(ctx, attachment.StorageKey)
+	if errors.Is(err, storage.ErrBlobNotFound) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while opening attachment: %w", err)
+	}
+
+	return attachment, body, nil
+}
+
+func (s *service) DeleteAttachment(ctx context.Context, attachmentID, userID string) error {
+	if userID == "" {
+		return fmt.Errorf("userID is required")
+	}
+
+	attachment, err := s.repo.DeleteAttachment(ctx, attachmentID, userID)
+	if err != nil {
+		return fmt.Errorf("error while deleting attachment: %w", err)
+	}
+
+	deleteBlobs(ctx, s.blobs, []string{attachment.StorageKey})
+
+	return nil
+}
+
+// newSecretToken returns 256 random bits, URL-safe encoded.
+func newSecretToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is how secret tokens are stored and looked up. The tokens are
+// random and long, so a plain SHA-256 is enough; no salt or slow hash needed.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newBlobKey returns a fresh, unguessable blob key grouped under the note.
+func newBlobKey(noteID string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate blob key: %w", err)
+	}
+	return noteID + "/" + hex.EncodeToString(b), nil
+}
+
+// deleteBlobs removes blobs whose database rows are already gone. A failure
+// only leaks storage, so it is logged rather than returned.
+func deleteBlobs(ctx context.Context, blobs storage.BlobStore, keys []string) {
+	for _, key := range keys {
+		if err := blobs.Delete(ctx, key); err != nil {
+			log.Printf("failed to delete blob %s: %v", key, err)
+		}
+	}
+}
+
+// cleanFilename keeps only the last path element of a client-supplied name.
+func cleanFilename(name string) string {
+	name = strings.TrimSpace(filepath.Base(strings.ReplaceAll(name, "\\", "/")))
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	return name
+}
+
+// normalizeTags trims and lower-cases tag names and drops duplicates while
+// keeping the original order. The result is never nil.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	out := make([]string, 0, len(tags))
+
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || len(t) > 50 {
+			return nil, ErrInvalidTag
+		}
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+
+	return out, nil
+}
+
+// customSlugPattern is what owners may pick as a slug: lowercase letters and
+// digits in hyphen-separated words, 3-100 characters in all.
+var customSlugPattern = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
 func slugify(title string) string {
 	s := strings.ToLower(title)
 	s = strings.ReplaceAll(s, " ", "-")
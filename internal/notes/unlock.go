@@ -0,0 +1,91 @@
+package notes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// unlockTokenTTL is how long a reader stays in after entering a note's
+	// passphrase.
+	unlockTokenTTL = 30 * time.Minute
+
+	// unlockAudience keeps unlock tokens from being mistaken for anything else
+	// signed by the server.
+	unlockAudience = "note-unlock"
+
+	// A single client gets a handful of guesses per window; the per-note cap
+	// is looser and only there to slow guessing spread over many addresses,
+	// by adding noteUnlockDelay to each attempt once it is reached.
+	clientUnlockAttempts = 5
+	noteUnlockAttempts   = 100
+	unlockAttemptWindow  = 15 * time.Minute
+	noteUnlockDelay      = 2 * time.Second
+
+	minNotePasswordLen = 8
+)
+
+// lockColumn returns a SQL expression for the password hash of the note
+// aliased n when the caller only reaches it as a public note, and NULL when
+// there is no password or the caller owns the note or has it shared with
+// them. Parameters are as for accessCondition.
+func lockColumn(userParam, emailParam int) string {
+	return "CASE WHEN n.password_hash IS NOT NULL AND NOT " +
+		memberCondition(userParam, emailParam) + " THEN n.password_hash END"
+}
+
+// unlockSigner issues and checks the tokens handed out after a successful
+// unlock. Each token names its note and carries a fingerprint of the
+// password hash, so changing or removing the password ends every session
+// opened with the old one.
+type unlockSigner struct {
+	key []byte
+}
+
+func newUnlockSigner(secret string) *unlockSigner {
+	// derive a separate key so an unlock token never verifies as a login
+	sum := sha256.Sum256([]byte("note-unlock:" + secret))
+	return &unlockSigner{key: sum[:]}
+}
+
+func (s *unlockSigner) issue(noteID, passwordHash string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"aud":     unlockAudience,
+		"note_id": noteID,
+		"pwv":     passwordFingerprint(passwordHash),
+		"exp":     time.Now().Add(unlockTokenTTL).Unix(),
+	})
+
+	return token.SignedString(s.key)
+}
+
+func (s *unlockSigner) valid(tokenString, noteID, passwordHash string) bool {
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (any, error) {
+		return s.key, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithAudience(unlockAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !token.Valid {
+		return false
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return false
+	}
+
+	id, _ := claims["note_id"].(string)
+	pwv, _ := claims["pwv"].(string)
+
+	return id == noteID && pwv == passwordFingerprint(passwordHash)
+}
+
+func passwordFingerprint(passwordHash string) string {
+	sum := sha256.Sum256([]byte(passwordHash))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,167 @@
+package notes
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// flushTimeout bounds a single batch write, including the last one made
+// while shutting down.
+const flushTimeout = 10 * time.Second
+
+// ViewRecorder buffers note views and writes them to the repository in
+// batches, so recording a view costs a read no more than a channel send.
+// Views arriving while the buffer is full are dropped, and views still
+// buffered when the process stops are lost; the counts are for owners'
+// curiosity, not billing.
+type ViewRecorder struct {
+	repo      NotesRepository
+	key       []byte
+	batchSize int
+	interval  time.Duration
+	views     chan NoteView
+	dropped   atomic.Int64
+}
+
+// NewViewRecorder returns a recorder buffering up to size views and writing
+// them once batchSize have piled up or every interval, whichever comes
+// first. Viewer keys are hashed with a key derived from secret. Nothing is
+// written until Run is started.
+func NewViewRecorder(r NotesRepository, secret string, size, batchSize int, interval time.Duration) *ViewRecorder {
+	// derive a key of its own so viewer keys reveal nothing about secret
+	key := sha256.Sum256([]byte("note-viewer:" + secret))
+
+	return &ViewRecorder{
+		repo:      r,
+		key:       key[:],
+		batchSize: max(batchSize, 1),
+		interval:  interval,
+		views:     make(chan NoteView, size),
+	}
+}
+
+// Record queues view without blocking.
+func (v *ViewRecorder) Record(view NoteView) {
+	view.ViewerKey = v.hashViewer(view.ViewerKey)
+
+	select {
+	case v.views <- view:
+	default:
+		v.dropped.Add(1)
+	}
+}
+
+// Run writes queued views until ctx is done, then writes what it already
+// holds. It is meant to be started in its own goroutine.
+func (v *ViewRecorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(v.interval)
+	defer ticker.Stop()
+
+	batch := make([]NoteView, 0, v.batchSize)
+
+	for {
+		select {
+		case <-ctx.Done():
+			v.flush(context.Background(), batch)
+			return
+		case view := <-v.views:
+			batch = append(batch, view)
+			if len(batch) < v.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+
+		v.flush(ctx, batch)
+		batch = batch[:0]
+	}
+}
+
+func (v *ViewRecorder) flush(ctx context.Context, batch []NoteView) {
+	if dropped := v.dropped.Swap(0); dropped > 0 {
+		log.Printf("view buffer full, dropped %d views", dropped)
+	}
+
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, flushTimeout)
+	defer cancel()
+
+	if err := v.repo.RecordViews(ctx, batch); err != nil {
+		log.Printf("recording %d views failed: %v", len(batch), err)
+	}
+}
+
+// viewerKey identifies a viewer for unique counts. Signed-in viewers are
+// keyed on their user id, anyone else on their address and user agent.
+func viewerKey(userID, client, userAgent string) string {
+	if userID != "" {
+		return "user:" + userID
+	}
+	return "anon:" + client + "|" + userAgent
+}
+
+// hashViewer turns a viewerKey into what is stored. It is an HMAC rather
+// than a plain hash because addresses are few enough to brute-force.
+func (v *ViewRecorder) hashViewer(viewer string) string {
+	mac := hmac.New(sha256.New, v.key)
+	mac.Write([]byte(viewer))
+	return hex.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// referrerHost reduces a Referer header to its host, so paths and query
+// strings of other sites are never stored.
+func referrerHost(referer string) string {
+	if referer == "" {
+		return ""
+	}
+
+	u, err := url.Parse(referer)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// agentBotHints and agentMobileHints are user agent substrings, matched case
+// insensitively, that mark crawlers and phones or tablets.
+var (
+	agentBotHints    = []string{"bot", "crawler", "spider", "slurp", "curl", "wget", "python-requests", "go-http-client", "headless", "preview"}
+	agentMobileHints = []string{"mobile", "android", "iphone", "ipad", "ipod"}
+)
+
+// classifyAgent sorts a User-Agent header into an AgentClass.
+func classifyAgent(userAgent string) AgentClass {
+	ua := strings.ToLower(userAgent)
+
+	switch {
+	case ua == "":
+		return AgentOther
+	case containsAny(ua, agentBotHints):
+		return AgentBot
+	case containsAny(ua, agentMobileHints):
+		return AgentMobile
+	case strings.HasPrefix(ua, "mozilla/"):
+		return AgentDesktop
+	default:
+		return AgentOther
+	}
+}
+
+func containsAny(s string, subs []string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
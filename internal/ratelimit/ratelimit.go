@@ -0,0 +1,103 @@
+// Package ratelimit throttles guessable secrets such as note passwords and
+// one-time codes by counting failures, and endpoints that send mail by
+// counting every request as one.
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxKeys is how many keys a Limiter tracks before it sweeps out expired
+// windows.
+const maxKeys = 4096
+
+// Limiter counts failed attempts per key over fixed windows. State is in
+// memory, so limits are per process and reset on restart.
+type Limiter struct {
+	max    int
+	window time.Duration
+
+	mu   sync.Mutex
+	hits map[string]*attemptWindow
+}
+
+type attemptWindow struct {
+	count int
+	reset time.Time
+}
+
+// New returns a limiter allowing max failures per key in each window.
+func New(max int, window time.Duration) *Limiter {
+	return &Limiter{
+		max:    max,
+		window: window,
+		hits:   make(map[string]*attemptWindow),
+	}
+}
+
+// RetryAfter returns how long key has to wait before trying again, or zero
+// if it may try now.
+func (l *Limiter) RetryAfter(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.hits[key]
+	if !ok || w.count < l.max {
+		return 0
+	}
+
+	wait := time.Until(w.reset)
+	if wait <= 0 {
+		delete(l.hits, key)
+		return 0
+	}
+	return wait
+}
+
+// Fail records a failed attempt for key.
+func (l *Limiter) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	w, ok := l.hits[key]
+	if !ok || now.After(w.reset) {
+		if len(l.hits) >= maxKeys {
+			l.sweep(now)
+		}
+		w = &attemptWindow{reset: now.Add(l.window)}
+		l.hits[key] = w
+	}
+	w.count++
+}
+
+// Clear forgets the failures of key, typically after a success.
+func (l *Limiter) Clear(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.hits, key)
+}
+
+// sweep drops expired windows. The caller holds l.mu.
+func (l *Limiter) sweep(now time.Time) {
+	for key, w := range l.hits {
+		if now.After(w.reset) {
+			delete(l.hits, key)
+		}
+	}
+}
+
+// ClientIP is the address a request's attempts are throttled by. The server
+// is not assumed to sit behind a proxy, so forwarding headers are ignored.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps opaque binary objects under string keys. The notes package
+// uses it for attachments so the bytes can live on local disk today and in an
+// S3-compatible bucket later without the callers changing.
+//
+// Keys are chosen by the caller and may contain "/" to group objects.
+type BlobStore interface {
+	// Put stores everything read from r under key, replacing any existing
+	// object, and returns the number of bytes written.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Get opens the object for reading. The caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+}
@@ -2,7 +2,13 @@ package user
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/middleware"
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/ratelimit"
 )
 
 type Handler struct {
@@ -38,15 +44,17 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 
 	// Never return password hash
 	resp := struct {
-		Id        string `json:"id"`
-		Name      string `json:"name"`
-		Email     string `json:"email"`
-		CreatedAt string `json:"created_at"`
+		Id            string `json:"id"`
+		Name          string `json:"name"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		CreatedAt     string `json:"created_at"`
 	}{
-		Id:        user.Id,
-		Name:      user.Name,
-		Email:     user.Email,
-		CreatedAt: user.CreatedAt.Format("2006-01-02 15:04:05"),
+		Id:            user.Id,
+		Name:          user.Name,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		CreatedAt:     user.CreatedAt.Format("2006-01-02 15:04:05"),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -69,18 +77,494 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, token, err := h.service.Login(r.Context(), req.Email, req.Password)
+	user, tokens, err := h.service.Login(r.Context(), req.Email, req.Password)
+
+	var challenge *MFAChallengeError
+	if errors.As(err, &challenge) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"mfa_required":    true,
+			"challenge_token": challenge.Token,
+			"expires_at":      challenge.ExpiresAt,
+		})
+		return
+	}
 
 	if err != nil {
 		http.Error(w, "invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		User *User `json:"user"`
+		*TokenPair
+	}{
+		User:      user,
+		TokenPair: tokens,
+	})
+}
+
+// Refresh trades a refresh token for a new access and refresh token pair.
+func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.service.Refresh(r.Context(), req.RefreshToken)
+
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Logout ends the session the caller's access token belongs to.
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := middleware.GetUserID(r.Context())
+	sessionID, _ := middleware.GetSessionID(r.Context())
+
+	if err := h.service.Logout(r.Context(), userID, sessionID); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "logged out successfully",
+	})
+}
+
+// LogoutEverywhere ends every session of the caller, on all devices.
+func (h *Handler) LogoutEverywhere(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	revoked, err := h.service.LogoutEverywhere(r.Context(), userID)
+
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":   "success",
+		"message":  "logged out everywhere",
+		"sessions": revoked,
+	})
+}
+
+// ForgotPassword mails a reset link if the email has an account. It answers
+// the same way whether or not it does.
+func (h *Handler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.ForgotPassword(r.Context(), req.Email, ratelimit.ClientIP(r)); err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "if an account uses that email, a reset link is on its way",
+	})
+}
+
+// ResetPassword sets a new password with the token from a reset link.
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.ResetPassword(r.Context(), req.Token, req.Password); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "password reset successfully, please log in again",
+	})
+}
+
+// VerifyEmail is where the link in verification emails points. It takes
+// the token as a query param so the link works when clicked.
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.service.VerifyEmail(r.Context(), r.URL.Query().Get("token")); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "email verified successfully",
+	})
+}
+
+// ResendVerification mails the caller a new verification link.
+func (h *Handler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.service.ResendVerification(r.Context(), userID); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "verification email sent",
+	})
+}
+
+// LoginMFA finishes a login for an account with two-factor authentication,
+// taking the challenge token Login answered with and a code from the
+// authenticator or a recovery code.
+func (h *Handler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, tokens, err := h.service.LoginMFA(r.Context(), req.ChallengeToken, req.Code)
+
+	if err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		User *User `json:"user"`
+		*TokenPair
+	}{
+		User:      user,
+		TokenPair: tokens,
+	})
+}
+
+// SetupMFA starts two-factor enrollment for the caller and returns the
+// secret and provisioning URI for their authenticator app.
+func (h *Handler) SetupMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	setup, err := h.service.SetupMFA(r.Context(), userID)
+
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(setup)
+}
+
+// ConfirmMFA enables two-factor authentication with a first code from the
+// authenticator, and answers with the recovery codes.
+func (h *Handler) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessionID, _ := middleware.GetSessionID(r.Context())
+
+	codes, err := h.service.ConfirmMFA(r.Context(), userID, sessionID, req.Code)
+
+	if err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":         "success",
+		"message":        "two-factor authentication enabled, store the recovery codes safely",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableMFA turns two-factor authentication off for the caller.
+func (h *Handler) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessionID, _ := middleware.GetSessionID(r.Context())
+
+	if err := h.service.DisableMFA(r.Context(), userID, sessionID, req.Password, req.Code); err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "two-factor authentication disabled",
+	})
+}
+
+// ListAPIKeys lists the caller's API keys, without the keys themselves.
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := h.service.ListAPIKeys(r.Context(), userID)
+
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// CreateAPIKey issues a named, scoped API key for the caller. The key is in
+// this response only.
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key, raw, err := h.service.CreateAPIKey(r.Context(), userID, req.Name, req.Scopes, req.ExpiresAt)
+
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(struct {
-		User  *User  `json:"user"`
-		Token string `json:"token"`
+		*APIKey
+		Key string `json:"key"`
 	}{
-		User:  user,
-		Token: token,
+		APIKey: key,
+		Key:    raw,
+	})
+}
+
+// RevokeAPIKey deletes one of the caller's API keys, given as ?id=.
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	keyID := r.URL.Query().Get("id")
+
+	if keyID == "" {
+		http.Error(w, "missing api key id param", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.RevokeAPIKey(r.Context(), userID, keyID); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "api key revoked successfully",
 	})
 }
+
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var throttled *TooManyAttemptsError
+	if errors.As(err, &throttled) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(throttled.RetryAfter.Seconds())+1))
+	}
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidResetToken), errors.Is(err, ErrWeakPassword),
+		errors.Is(err, ErrInvalidEmail),
+		errors.Is(err, ErrInvalidVerifyToken):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrMFANotEnabled), errors.Is(err, ErrMFANotSetUp),
+		errors.Is(err, ErrInvalidAPIKeyName), errors.Is(err, ErrInvalidScope),
+		errors.Is(err, ErrInvalidExpiry):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrAPIKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrAlreadyVerified), errors.Is(err, ErrMFAAlreadyEnabled):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidRefreshToken), errors.Is(err, ErrRefreshTokenReused),
+		errors.Is(err, ErrSessionNotFound), errors.Is(err, ErrInvalidChallenge),
+		errors.Is(err, ErrInvalidMFACode), errors.Is(err, ErrInvalidLogin),
+		errors.Is(err, ErrInvalidAPIKey):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrTooManyAttempts):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrMFAUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,324 @@
+package user
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// challengeTTL is how long a user has between entering their password
+	// and entering the code from their authenticator.
+	challengeTTL = 5 * time.Minute
+
+	// challengeAudience keeps challenge tokens from being mistaken for
+	// anything else signed by the server.
+	challengeAudience = "mfa-challenge"
+
+	// Six digit codes are easy to guess given enough tries, so each account
+	// gets a handful per window across all its challenges.
+	mfaAttempts      = 5
+	mfaAttemptWindow = 15 * time.Minute
+)
+
+// MFAChallengeError is what Login returns for accounts with MFA enabled once
+// the password checks out. Token goes to LoginMFA along with a code. It
+// unwraps to ErrMFARequired.
+type MFAChallengeError struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+func (e *MFAChallengeError) Error() string {
+	return ErrMFARequired.Error()
+}
+
+func (e *MFAChallengeError) Unwrap() error {
+	return ErrMFARequired
+}
+
+// TooManyAttemptsError is returned when an account's codes have been
+// guessed wrong too often. It unwraps to ErrTooManyAttempts.
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("%v, retry in %s", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e *TooManyAttemptsError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+// challengeSigner issues and checks the tokens that carry a login from the
+// password step to the code step.
+type challengeSigner struct {
+	key []byte
+}
+
+func newChallengeSigner(secret string) *challengeSigner {
+	// derive a separate key so a challenge never verifies as a login
+	sum := sha256.Sum256([]byte("mfa-challenge:" + secret))
+	return &challengeSigner{key: sum[:]}
+}
+
+func (c *challengeSigner) issue(userID string) (string, time.Time, error) {
+	expiresAt := time.Now().Add(challengeTTL)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"aud":     challengeAudience,
+		"user_id": userID,
+		"exp":     expiresAt.Unix(),
+	})
+
+	signed, err := token.SignedString(c.key)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate challenge token: %w", err)
+	}
+
+	return signed, expiresAt, nil
+}
+
+// userID returns who the challenge was issued to, or "" if it is not valid.
+func (c *challengeSigner) userID(tokenString string) string {
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (any, error) {
+		return c.key, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithAudience(challengeAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !token.Valid {
+		return ""
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+
+	id, _ := claims["user_id"].(string)
+	return id
+}
+
+// challenge builds the error Login answers with for an MFA account.
+func (s *service) challenge(u *User) error {
+	token, expiresAt, err := s.challenges.issue(u.Id)
+	if err != nil {
+		return err
+	}
+
+	return &MFAChallengeError{Token: token, ExpiresAt: expiresAt}
+}
+
+// LoginMFA finishes a login started by Login, taking either a code from the
+// authenticator or one of the recovery codes.
+func (s *service) LoginMFA(ctx context.Context, challenge, code string) (*User, *TokenPair, error) {
+	userID := s.challenges.userID(challenge)
+	if userID == "" {
+		return nil, nil, ErrInvalidChallenge
+	}
+
+	if err := s.checkSecondFactor(ctx, userID, code); err != nil {
+		return nil, nil, err
+	}
+
+	u, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, nil, ErrInvalidChallenge
+	}
+
+	u.Password = ""
+
+	tokens, err := s.startSession(ctx, u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return u, tokens, nil
+}
+
+// SetupMFA starts enrollment with a fresh secret. Nothing changes for logins
+// until ConfirmMFA; calling it again replaces the pending secret.
+func (s *service) SetupMFA(ctx context.Context, userID string) (*MFASetup, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("userID is required")
+	}
+
+	u, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	if s.secrets == nil {
+		return nil, ErrMFAUnavailable
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := s.secrets.seal(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.SetTOTPSecret(ctx, userID, sealed); err != nil {
+		return nil, err
+	}
+
+	return &MFASetup{Secret: secret, URI: totpURI(u.Email, secret)}, nil
+}
+
+// ConfirmMFA turns MFA on once code shows the authenticator was set up with
+// the pending secret, and logs out every session but sessionID. It returns
+// the recovery codes, which are not shown again.
+func (s *service) ConfirmMFA(ctx context.Context, userID, sessionID, code string) ([]string, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("userID is required")
+	}
+
+	if s.secrets == nil {
+		return nil, ErrMFAUnavailable
+	}
+
+	if err := s.throttled(userID); err != nil {
+		return nil, err
+	}
+
+	t, err := s.repo.GetTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if t.Secret == nil {
+		return nil, ErrMFANotSetUp
+	}
+
+	// a pending secret sealed under an older key is simply set up again
+	secret, err := s.secrets.open(*t.Secret)
+	if err != nil {
+		return nil, ErrMFANotSetUp
+	}
+
+	step, ok := matchTOTP(secret, code, time.Now(), t.LastStep)
+	if !ok {
+		s.mfaTries.Fail(userID)
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = hashToken(c)
+	}
+
+	if err := s.repo.EnableTOTP(ctx, userID, sessionID, step, hashes); err != nil {
+		return nil, err
+	}
+
+	s.mfaTries.Clear(userID)
+
+	return codes, nil
+}
+
+// DisableMFA turns MFA off and logs out every session but sessionID. It
+// takes both the password and a current code (or recovery code), so a
+// stolen session alone cannot remove the second factor.
+func (s *service) DisableMFA(ctx context.Context, userID, sessionID, password, code string) error {
+	if userID == "" {
+		return fmt.Errorf("userID is required")
+	}
+
+	u, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) != nil {
+		return ErrInvalidLogin
+	}
+
+	if err := s.checkSecondFactor(ctx, userID, code); err != nil {
+		return err
+	}
+
+	return s.repo.DisableTOTP(ctx, userID, sessionID)
+}
+
+// checkSecondFactor accepts a current TOTP code or an unused recovery code
+// for userID, using it up either way. Recovery codes are hashed rather than
+// encrypted, so they keep working even if the TOTP secret cannot be read.
+func (s *service) checkSecondFactor(ctx context.Context, userID, code string) error {
+	if err := s.throttled(userID); err != nil {
+		return err
+	}
+
+	t, err := s.repo.GetTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if !t.Enabled {
+		return ErrMFANotEnabled
+	}
+
+	if step, ok := s.matchTOTP(userID, t, code); ok {
+		err = s.repo.UseTOTPStep(ctx, userID, step)
+	} else {
+		err = s.repo.UseRecoveryCode(ctx, userID, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	if errors.Is(err, ErrInvalidMFACode) {
+		s.mfaTries.Fail(userID)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mfaTries.Clear(userID)
+
+	return nil
+}
+
+// matchTOTP checks code against the user's stored secret. A secret that
+// cannot be decrypted is logged and matches nothing.
+func (s *service) matchTOTP(userID string, t *TOTP, code string) (int64, bool) {
+	if s.secrets == nil || t.Secret == nil {
+		return 0, false
+	}
+
+	secret, err := s.secrets.open(*t.Secret)
+	if err != nil {
+		log.Printf("totp secret of %s unreadable: %v", userID, err)
+		return 0, false
+	}
+
+	return matchTOTP(secret, code, time.Now(), t.LastStep)
+}
+
+func (s *service) throttled(userID string) error {
+	if wait := s.mfaTries.RetryAfter(userID); wait > 0 {
+		return &TooManyAttemptsError{RetryAfter: wait}
+	}
+	return nil
+}
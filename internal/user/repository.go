@@ -2,8 +2,11 @@ package user
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -37,7 +40,7 @@ RETURNING id, created_at
 
 func (r *postgresUserRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id, email, name, password, created_at
+		SELECT id, email, name, password, email_verified, totp_enabled, created_at
 		FROM users
 		WHERE email = $1
 	`
@@ -45,10 +48,567 @@ func (r *postgresUserRepository) GetUserByEmail(ctx context.Context, email strin
 	row := r.db.QueryRow(ctx, query, email)
 
 	var u User
-	err := row.Scan(&u.Id, &u.Email, &u.Name, &u.Password, &u.CreatedAt)
+	err := row.Scan(&u.Id, &u.Email, &u.Name, &u.Password, &u.EmailVerified, &u.MFAEnabled, &u.CreatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("user not found: %w", err)
 	}
 
 	return &u, nil
 }
+
+func (r *postgresUserRepository) GetUserByID(ctx context.Context, id string) (*User, error) {
+	query := `
+		SELECT id, email, name, password, email_verified, totp_enabled, created_at
+		FROM users
+		WHERE id = $1
+	`
+
+	row := r.db.QueryRow(ctx, query, id)
+
+	var u User
+	err := row.Scan(&u.Id, &u.Email, &u.Name, &u.Password, &u.EmailVerified, &u.MFAEnabled, &u.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+
+	return &u, nil
+}
+
+// CreateSession starts a session for userID holding its first refresh
+// token, and returns the session id.
+func (r *postgresUserRepository) CreateSession(ctx context.Context, userID, refreshHash string, expiresAt time.Time) (string, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error creating session: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var sessionID string
+	err = tx.QueryRow(ctx, `INSERT INTO sessions(user_id) VALUES ($1) RETURNING id`, userID).Scan(&sessionID)
+	if err != nil {
+		return "", fmt.Errorf("error creating session: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+	INSERT INTO refresh_tokens(session_id, token_hash, expires_at)
+	VALUES ($1, $2, $3)
+	`, sessionID, refreshHash, expiresAt)
+	if err != nil {
+		return "", fmt.Errorf("error storing refresh token: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("error creating session: %w", err)
+	}
+
+	return sessionID, nil
+}
+
+// RotateRefreshToken uses up the refresh token hashed as oldHash and stores
+// newHash as its successor in the same session. A token that was already
+// used ends its session: the revocation is committed and
+// ErrRefreshTokenReused returned.
+func (r *postgresUserRepository) RotateRefreshToken(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (*Session, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error refreshing session: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		tokenID   string
+		session   Session
+		usedAt    *time.Time
+		revokedAt *time.Time
+		expires   time.Time
+	)
+	err = tx.QueryRow(ctx, `
+	SELECT rt.id, rt.used_at, rt.expires_at, s.id, s.revoked_at, u.id, u.email
+	FROM refresh_tokens rt
+	JOIN sessions s ON s.id = rt.session_id
+	JOIN users u ON u.id = s.user_id
+	WHERE rt.token_hash = $1
+	FOR UPDATE OF rt, s
+	`, oldHash).Scan(&tokenID, &usedAt, &expires, &session.ID, &revokedAt, &session.UserID, &session.Email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error refreshing session: %w", err)
+	}
+
+	if revokedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if usedAt != nil {
+		if _, err := tx.Exec(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE id = $1`, session.ID); err != nil {
+			return nil, fmt.Errorf("error revoking session: %w", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("error revoking session: %w", err)
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	if !expires.After(time.Now()) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET used_at = NOW() WHERE id = $1`, tokenID); err != nil {
+		return nil, fmt.Errorf("error refreshing session: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+	INSERT INTO refresh_tokens(session_id, token_hash, expires_at)
+	VALUES ($1, $2, $3)
+	`, session.ID, newHash, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("error storing refresh token: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE sessions SET last_used_at = NOW() WHERE id = $1`, session.ID); err != nil {
+		return nil, fmt.Errorf("error refreshing session: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error refreshing session: %w", err)
+	}
+
+	return &session, nil
+}
+
+func (r *postgresUserRepository) SessionActive(ctx context.Context, userID, sessionID string) (bool, error) {
+	var active bool
+	err := r.db.QueryRow(ctx, `
+	SELECT EXISTS(SELECT 1 FROM sessions WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL)
+	`, sessionID, userID).Scan(&active)
+	if err != nil {
+		return false, fmt.Errorf("error checking session: %w", err)
+	}
+
+	return active, nil
+}
+
+func (r *postgresUserRepository) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	cmdTag, err := r.db.Exec(ctx, `
+	UPDATE sessions SET revoked_at = NOW()
+	WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("error revoking session: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// RevokeAllSessions logs out every session of userID and deletes the user's
+// API keys with them, so nothing issued before the call keeps working. It
+// returns how many sessions were open.
+func (r *postgresUserRepository) RevokeAllSessions(ctx context.Context, userID string) (int64, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error revoking sessions: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	cmdTag, err := tx.Exec(ctx, `
+	UPDATE sessions SET revoked_at = NOW()
+	WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("error revoking sessions: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM api_keys WHERE user_id = $1`, userID); err != nil {
+		return 0, fmt.Errorf("error revoking api keys: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("error revoking sessions: %w", err)
+	}
+
+	return cmdTag.RowsAffected(), nil
+}
+
+// CreatePasswordReset stores a reset token for userID, unless one issued
+// less than minInterval ago is still live; created reports which. Used and
+// expired tokens of the user are deleted on the way, so the table only holds
+// a few rows per user however often resets are asked for.
+func (r *postgresUserRepository) CreatePasswordReset(ctx context.Context, userID, tokenHash string, expiresAt time.Time, minInterval time.Duration) (bool, error) {
+	cmdTag, err := r.db.Exec(ctx, `
+	WITH cleared AS (
+		DELETE FROM password_resets
+		WHERE user_id = $1 AND (used_at IS NOT NULL OR expires_at <= NOW())
+	)
+	INSERT INTO password_resets(user_id, token_hash, expires_at)
+	SELECT $1, $2, $3
+	WHERE NOT EXISTS (
+		SELECT 1 FROM password_resets
+		WHERE user_id = $1 AND used_at IS NULL AND expires_at > NOW()
+		  AND created_at > NOW() - $4::interval
+	)
+	`, userID, tokenHash, expiresAt, minInterval)
+	if err != nil {
+		return false, fmt.Errorf("error storing password reset: %w", err)
+	}
+
+	return cmdTag.RowsAffected() > 0, nil
+}
+
+// ResetPassword uses up the reset token hashed as tokenHash and gives its
+// user passwordHash. Every other open reset token of the user is used up
+// with it, all their sessions are logged out and their API keys deleted,
+// since a reset often follows a compromise.
+func (r *postgresUserRepository) ResetPassword(ctx context.Context, tokenHash, passwordHash string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error resetting password: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var userID string
+	err = tx.QueryRow(ctx, `
+	SELECT user_id FROM password_resets
+	WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+	FOR UPDATE
+	`, tokenHash).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return fmt.Errorf("error resetting password: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE users SET password = $1 WHERE id = $2`, passwordHash, userID); err != nil {
+		return fmt.Errorf("error resetting password: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+	UPDATE password_resets SET used_at = NOW()
+	WHERE user_id = $1 AND used_at IS NULL
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("error resetting password: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+	UPDATE sessions SET revoked_at = NOW()
+	WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("error revoking sessions: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM api_keys WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("error revoking api keys: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error resetting password: %w", err)
+	}
+
+	return nil
+}
+
+func (r *postgresUserRepository) CreateEmailVerification(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	_, err := r.db.Exec(ctx, `
+	INSERT INTO email_verifications(user_id, token_hash, expires_at)
+	VALUES ($1, $2, $3)
+	`, userID, tokenHash, expiresAt)
+	if err != nil {
+		return fmt.Errorf("error storing email verification: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyEmail uses up the verification token hashed as tokenHash, along with
+// any other open one of the same user, and marks the user's email verified.
+func (r *postgresUserRepository) VerifyEmail(ctx context.Context, tokenHash string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error verifying email: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var userID string
+	err = tx.QueryRow(ctx, `
+	SELECT user_id FROM email_verifications
+	WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+	FOR UPDATE
+	`, tokenHash).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrInvalidVerifyToken
+	}
+	if err != nil {
+		return fmt.Errorf("error verifying email: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE users SET email_verified = TRUE WHERE id = $1`, userID); err != nil {
+		return fmt.Errorf("error verifying email: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+	UPDATE email_verifications SET used_at = NOW()
+	WHERE user_id = $1 AND used_at IS NULL
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("error verifying email: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error verifying email: %w", err)
+	}
+
+	return nil
+}
+
+func (r *postgresUserRepository) GetTOTP(ctx context.Context, userID string) (*TOTP, error) {
+	var t TOTP
+	err := r.db.QueryRow(ctx, `
+	SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = $1
+	`, userID).Scan(&t.Secret, &t.Enabled, &t.LastStep)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+
+	return &t, nil
+}
+
+// SetTOTPSecret starts (or restarts) enrollment with a new secret. It
+// leaves accounts that already have MFA enabled alone.
+func (r *postgresUserRepository) SetTOTPSecret(ctx context.Context, userID, sealedSecret string) error {
+	cmdTag, err := r.db.Exec(ctx, `
+	UPDATE users SET totp_secret = $2, totp_last_step = 0
+	WHERE id = $1 AND NOT totp_enabled
+	`, userID, sealedSecret)
+	if err != nil {
+		return fmt.Errorf("error storing totp secret: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+// EnableTOTP turns MFA on once a code for the pending secret has been
+// confirmed at step, replaces the user's recovery codes and revokes every
+// session of the user but keepSessionID.
+func (r *postgresUserRepository) EnableTOTP(ctx context.Context, userID, keepSessionID string, step int64, recoveryHashes []string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error enabling mfa: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	cmdTag, err := tx.Exec(ctx, `
+	UPDATE users SET totp_enabled = TRUE, totp_last_step = $2
+	WHERE id = $1 AND NOT totp_enabled AND totp_secret IS NOT NULL
+	`, userID, step)
+	if err != nil {
+		return fmt.Errorf("error enabling mfa: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("error storing recovery codes: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+	INSERT INTO recovery_codes(user_id, code_hash)
+	SELECT $1, unnest($2::text[])
+	`, userID, recoveryHashes)
+	if err != nil {
+		return fmt.Errorf("error storing recovery codes: %w", err)
+	}
+
+	if err := revokeOtherSessions(ctx, tx, userID, keepSessionID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error enabling mfa: %w", err)
+	}
+
+	return nil
+}
+
+// UseTOTPStep records that a code for step was accepted. It fails with
+// ErrInvalidMFACode if that step or a later one was used already, so two
+// logins racing with the same code cannot both get through.
+func (r *postgresUserRepository) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	cmdTag, err := r.db.Exec(ctx, `
+	UPDATE users SET totp_last_step = $2
+	WHERE id = $1 AND totp_enabled AND totp_last_step < $2
+	`, userID, step)
+	if err != nil {
+		return fmt.Errorf("error checking totp code: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+func (r *postgresUserRepository) UseRecoveryCode(ctx context.Context, userID, codeHash string) error {
+	cmdTag, err := r.db.Exec(ctx, `
+	UPDATE recovery_codes SET used_at = NOW()
+	WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, codeHash)
+	if err != nil {
+		return fmt.Errorf("error checking recovery code: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// DisableTOTP turns MFA off, forgets the secret and recovery codes and
+// revokes every session of the user but keepSessionID.
+func (r *postgresUserRepository) DisableTOTP(ctx context.Context, userID, keepSessionID string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error disabling mfa: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	cmdTag, err := tx.Exec(ctx, `
+	UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = 0
+	WHERE id = $1 AND totp_enabled
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("error disabling mfa: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return ErrMFANotEnabled
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("error disabling mfa: %w", err)
+	}
+
+	if err := revokeOtherSessions(ctx, tx, userID, keepSessionID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error disabling mfa: %w", err)
+	}
+
+	return nil
+}
+
+func (r *postgresUserRepository) CreateAPIKey(ctx context.Context, key *APIKey, keyHash string) error {
+	err := r.db.QueryRow(ctx, `
+	INSERT INTO api_keys(user_id, name, prefix, key_hash, scopes, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at
+	`, key.UserID, key.Name, key.Prefix, keyHash, key.Scopes, key.ExpiresAt).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error storing api key: %w", err)
+	}
+
+	return nil
+}
+
+func (r *postgresUserRepository) ListAPIKeys(ctx context.Context, userID string) ([]*APIKey, error) {
+	rows, err := r.db.Query(ctx, `
+	SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at
+	FROM api_keys
+	WHERE user_id = $1
+	ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.Scopes, &k.ExpiresAt, &k.LastUsedAt, &k.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning api key: %w", err)
+		}
+		keys = append(keys, &k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+func (r *postgresUserRepository) DeleteAPIKey(ctx context.Context, userID, id string) error {
+	cmdTag, err := r.db.Exec(ctx, `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return fmt.Errorf("error revoking api key: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// UseAPIKey looks up the unexpired key hashed as keyHash, with its owner's
+// email, and stamps it as used. The stamp is only written when the last one
+// is over a minute old, so a busy key does not turn every read into a write.
+func (r *postgresUserRepository) UseAPIKey(ctx context.Context, keyHash string) (*APIKey, error) {
+	var k APIKey
+	err := r.db.QueryRow(ctx, `
+	SELECT k.id, k.user_id, k.name, k.prefix, k.scopes, k.expires_at, k.last_used_at, k.created_at, u.email
+	FROM api_keys k
+	JOIN users u ON u.id = k.user_id
+	WHERE k.key_hash = $1 AND (k.expires_at IS NULL OR k.expires_at > NOW())
+	`, keyHash).Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.Scopes, &k.ExpiresAt, &k.LastUsedAt, &k.CreatedAt, &k.OwnerEmail)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error checking api key: %w", err)
+	}
+
+	if k.LastUsedAt == nil || time.Since(*k.LastUsedAt) > time.Minute {
+		_, err := r.db.Exec(ctx, `
+		UPDATE api_keys SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+		`, k.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error updating api key: %w", err)
+		}
+	}
+
+	return &k, nil
+}
+
+// revokeOtherSessions logs out every session of userID except keepSessionID,
+// after a change to how the account signs in.
+func revokeOtherSessions(ctx context.Context, tx pgx.Tx, userID, keepSessionID string) error {
+	_, err := tx.Exec(ctx, `
+	UPDATE sessions SET revoked_at = NOW()
+	WHERE user_id = $1 AND id::text <> $2 AND revoked_at IS NULL
+	`, userID, keepSessionID)
+	if err != nil {
+		return fmt.Errorf("error revoking sessions: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package user
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/mail"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// resetTokenTTL is how long a password reset link works.
+	resetTokenTTL = time.Hour
+
+	// resetResendInterval is how long a link has to be out before asking
+	// again sends another one.
+	resetResendInterval = 5 * time.Minute
+
+	// Reset requests are cheap to make and each may send a mail, so they
+	// are limited per address asked for and per client asking.
+	resetEmailRequests  = 3
+	resetClientRequests = 20
+	resetRequestWindow  = time.Hour
+
+	// resetIssueTimeout bounds the work done after ForgotPassword returns.
+	resetIssueTimeout = 30 * time.Second
+)
+
+// ForgotPassword mails a reset link to email if an account uses it. The
+// lookup and mail happen after it returns, so neither the answer nor the
+// time it takes tells callers which emails have accounts. Requests are
+// throttled per email and per client, whether or not the email is known.
+func (s *service) ForgotPassword(ctx context.Context, email, client string) error {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return ErrInvalidEmail
+	}
+
+	emailKey := strings.ToLower(email)
+
+	wait := max(s.resetEmails.RetryAfter(emailKey), s.resetClients.RetryAfter(client))
+	if wait > 0 {
+		return &TooManyAttemptsError{RetryAfter: wait}
+	}
+
+	// every request counts, not only failed ones
+	s.resetEmails.Fail(emailKey)
+	s.resetClients.Fail(client)
+
+	go s.issuePasswordReset(context.WithoutCancel(ctx), email)
+
+	return nil
+}
+
+// issuePasswordReset does the work of ForgotPassword. Failures are only
+// logged, as nobody is waiting for the result.
+func (s *service) issuePasswordReset(ctx context.Context, email string) {
+	ctx, cancel := context.WithTimeout(ctx, resetIssueTimeout)
+	defer cancel()
+
+	u, err := s.repo.GetUserByEmail(ctx, email)
+	if err != nil || u == nil {
+		return
+	}
+
+	token, err := newSecretToken()
+	if err != nil {
+		log.Printf("password reset for %s not issued: %v", u.Id, err)
+		return
+	}
+
+	created, err := s.repo.CreatePasswordReset(ctx, u.Id, hashToken(token), time.Now().Add(resetTokenTTL), resetResendInterval)
+	if err != nil {
+		log.Printf("password reset for %s not issued: %v", u.Id, err)
+		return
+	}
+	if !created {
+		// a link sent moments ago is still good
+		return
+	}
+
+	mail.SendBestEffort(ctx, s.mailer, "password_reset", u.Email, mail.PasswordReset{
+		Name:      u.Name,
+		Link:      s.appURL + "/reset-password?token=" + url.QueryEscape(token),
+		ExpiresIn: "1 hour",
+	})
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword. The
+// token works once; every session of the account is logged out and its API
+// keys deleted.
+func (s *service) ResetPassword(ctx context.Context, token, password string) error {
+	if token == "" {
+		return ErrInvalidResetToken
+	}
+	if len(password) < 8 {
+		return ErrWeakPassword
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.ResetPassword(ctx, hashToken(token), string(hashed))
+}
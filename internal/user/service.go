@@ -3,12 +3,14 @@ package user
 import (
 	"context"
 	"errors"
-	"fmt"
+	"log"
 	"os"
 	"regexp"
+	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/mail"
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/ratelimit"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -17,16 +19,62 @@ var (
 	ErrWeakPassword = errors.New("password length is less than 8 chars")
 	ErrEmailExists  = errors.New("email provided is already in use")
 	ErrInvalidLogin = errors.New("wrong email/password combination provided")
+
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used; the session has been logged out")
+	ErrSessionNotFound     = errors.New("session not found or already logged out")
+
+	ErrInvalidResetToken = errors.New("reset link is invalid, expired or already used")
+
+	ErrInvalidVerifyToken = errors.New("verification link is invalid, expired or already used")
+	ErrAlreadyVerified    = errors.New("email is already verified")
+
+	ErrMFARequired       = errors.New("a second factor is required to log in")
+	ErrInvalidChallenge  = errors.New("login challenge is invalid or expired, log in again")
+	ErrInvalidMFACode    = errors.New("authentication code is invalid or already used")
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrMFANotSetUp       = errors.New("two-factor setup has not been started")
+	ErrTooManyAttempts   = errors.New("too many authentication attempts")
+	ErrMFAUnavailable    = errors.New("two-factor authentication is not configured on this server")
+
+	ErrInvalidAPIKeyName = errors.New("api key name must be 1 to 100 characters")
+	ErrInvalidScope      = errors.New("api key needs at least one scope of notes:read, notes:write or shares:manage")
+	ErrInvalidExpiry     = errors.New("api key expiry must be in the future")
+	ErrAPIKeyNotFound    = errors.New("api key not found")
+	ErrInvalidAPIKey     = errors.New("api key is invalid or expired")
 )
 
 var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
 
 type service struct {
-	repo UserRepository
+	repo   UserRepository
+	mailer mail.Mailer
+	appURL string
+
+	secrets      *secretBox
+	challenges   *challengeSigner
+	mfaTries     *ratelimit.Limiter
+	resetEmails  *ratelimit.Limiter
+	resetClients *ratelimit.Limiter
 }
 
-func NewService(r UserRepository) Service {
-	return &service{repo: r}
+// NewService returns the user service. Account emails go through mailer,
+// with links built on appURL; a nil mailer turns them off. TOTP secrets are
+// encrypted with TOTP_ENCRYPTION_KEY; without it nobody can enroll in MFA.
+func NewService(r UserRepository, mailer mail.Mailer, appURL string) Service {
+	secret := os.Getenv("JWT_SECRET")
+
+	return &service{
+		repo:         r,
+		mailer:       mailer,
+		appURL:       strings.TrimSuffix(appURL, "/"),
+		secrets:      newSecretBox(os.Getenv("TOTP_ENCRYPTION_KEY")),
+		challenges:   newChallengeSigner(secret),
+		mfaTries:     ratelimit.New(mfaAttempts, mfaAttemptWindow),
+		resetEmails:  ratelimit.New(resetEmailRequests, resetRequestWindow),
+		resetClients: ratelimit.New(resetClientRequests, resetRequestWindow),
+	}
 }
 
 func (s *service) Register(ctx context.Context, email, name, password string) (*User, error) {
@@ -61,33 +109,43 @@ func (s *service) Register(ctx context.Context, email, name, password string) (*
 	}
 
 	user.Password = ""
+
+	// the account exists either way; without a link the user can ask again
+	link, err := s.newVerifyLink(ctx, user.Id)
+	if err != nil {
+		log.Printf("email verification for %s not issued: %v", user.Id, err)
+	}
+
+	mail.SendBestEffort(ctx, s.mailer, "welcome", user.Email, mail.Welcome{
+		Name:       user.Name,
+		AppURL:     s.appURL,
+		VerifyLink: link,
+		ExpiresIn:  verifyTokenTTLText,
+	})
+
 	return user, nil
 }
 
-func (s *service) Login(ctx context.Context, email, password string) (*User, string, error) {
+func (s *service) Login(ctx context.Context, email, password string) (*User, *TokenPair, error) {
 	u, err := s.repo.GetUserByEmail(ctx, email)
 	if err != nil || u == nil {
-		return nil, "", ErrInvalidLogin
+		return nil, nil, ErrInvalidLogin
 	}
 
 	if bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) != nil {
-		return nil, "", ErrInvalidLogin
+		return nil, nil, ErrInvalidLogin
 	}
 
 	u.Password = ""
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": u.Id,
-		"email":   u.Email,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
-	})
-
-	secret := []byte(os.Getenv("JWT_SECRET"))
+	if u.MFAEnabled {
+		return nil, nil, s.challenge(u)
+	}
 
-	tokenString, err := token.SignedString(secret)
+	tokens, err := s.startSession(ctx, u)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to generate jwt token: %w", err)
+		return nil, nil, err
 	}
 
-	return u, tokenString, nil
+	return u, tokens, nil
 }
@@ -0,0 +1,121 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// accessTokenTTL is kept short because an access token is only checked
+	// against its session, not looked up itself; refreshTokenTTL is how long
+	// a session may sit unused before it has to log in again.
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// startSession opens a session for u and returns its first token pair.
+func (s *service) startSession(ctx context.Context, u *User) (*TokenPair, error) {
+	refresh, err := newSecretToken()
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID, err := s.repo.CreateSession(ctx, u.Id, hashToken(refresh), time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	return issueTokens(&Session{ID: sessionID, UserID: u.Id, Email: u.Email}, refresh)
+}
+
+// Refresh trades a refresh token for a new pair. The old refresh token stops
+// working; presenting it again logs its session out.
+func (s *service) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	next, err := newSecretToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.repo.RotateRefreshToken(ctx, hashToken(refreshToken), hashToken(next), time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	return issueTokens(session, next)
+}
+
+func (s *service) Logout(ctx context.Context, userID, sessionID string) error {
+	if userID == "" || sessionID == "" {
+		return ErrSessionNotFound
+	}
+
+	return s.repo.RevokeSession(ctx, userID, sessionID)
+}
+
+// LogoutEverywhere ends every session of userID, including the caller's,
+// deletes their API keys and returns how many sessions were open.
+func (s *service) LogoutEverywhere(ctx context.Context, userID string) (int64, error) {
+	if userID == "" {
+		return 0, fmt.Errorf("userID is required")
+	}
+
+	return s.repo.RevokeAllSessions(ctx, userID)
+}
+
+// SessionActive lets the auth middleware turn away access tokens of
+// sessions that have been logged out.
+func (s *service) SessionActive(ctx context.Context, userID, sessionID string) (bool, error) {
+	return s.repo.SessionActive(ctx, userID, sessionID)
+}
+
+func issueTokens(session *Session, refreshToken string) (*TokenPair, error) {
+	expiresAt := time.Now().Add(accessTokenTTL)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": session.UserID,
+		"email":   session.Email,
+		"sid":     session.ID,
+		"iat":     time.Now().Unix(),
+		"exp":     expiresAt.Unix(),
+	})
+
+	secret := []byte(os.Getenv("JWT_SECRET"))
+
+	tokenString, err := token.SignedString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate jwt token: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:  tokenString,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt,
+	}, nil
+}
+
+// newSecretToken returns 32 random bytes, base64url encoded, for refresh
+// and reset tokens. Only its hash is stored.
+func newSecretToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -3,22 +3,130 @@ package user
 import (
 	"context"
 	"time"
+
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/middleware"
 )
 
+// User is an account. EmailVerified is set once the user has followed the
+// verification link sent to Email; until then notes shared with that email
+// stay out of reach. MFAEnabled means logging in also takes a TOTP code.
 type User struct {
-	Id        string    `json:"id"`
-	Email     string    `json:"email"`
-	Name      string    `json:"name"`
-	Password  string    `json:"password,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
+	Id            string    `json:"id"`
+	Email         string    `json:"email"`
+	Name          string    `json:"name"`
+	Password      string    `json:"password,omitempty"`
+	EmailVerified bool      `json:"email_verified"`
+	MFAEnabled    bool      `json:"mfa_enabled"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// TokenPair is what a login or refresh hands out: a short-lived access token
+// for the Authorization header and the single-use refresh token that gets
+// the next pair. ExpiresAt is when the access token runs out.
+type TokenPair struct {
+	AccessToken  string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// TOTP is a user's authenticator enrollment. Secret is encrypted and nil
+// until setup has started; LastStep is the last time step a code was
+// accepted for.
+type TOTP struct {
+	Secret   *string
+	Enabled  bool
+	LastStep int64
+}
+
+// MFASetup is what an authenticator app needs to enroll: the base32 secret
+// for typing in, and the otpauth:// URI to show as a QR code.
+type MFASetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// APIKey is a personal key for scripts and integrations. The key itself is
+// only returned when it is created; Prefix is its start, for telling keys
+// apart. A nil ExpiresAt means the key works until it is revoked. Resetting
+// the password or logging out everywhere deletes all of a user's keys, so a
+// key made by someone who took over the account does not outlive them.
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	// OwnerEmail is filled in when a key is looked up to authenticate.
+	OwnerEmail string `json:"-"`
+}
+
+// Session is the login a refresh token belongs to.
+type Session struct {
+	ID     string
+	UserID string
+	Email  string
 }
 
 type UserRepository interface {
 	CreateUser(ctx context.Context, user *User) error
 	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetUserByID(ctx context.Context, id string) (*User, error)
+
+	CreateSession(ctx context.Context, userID, refreshHash string, expiresAt time.Time) (string, error)
+	RotateRefreshToken(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (*Session, error)
+	SessionActive(ctx context.Context, userID, sessionID string) (bool, error)
+	RevokeSession(ctx context.Context, userID, sessionID string) error
+	RevokeAllSessions(ctx context.Context, userID string) (int64, error)
+
+	CreatePasswordReset(ctx context.Context, userID, tokenHash string, expiresAt time.Time, minInterval time.Duration) (bool, error)
+	ResetPassword(ctx context.Context, tokenHash, passwordHash string) error
+
+	CreateEmailVerification(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error
+	VerifyEmail(ctx context.Context, tokenHash string) error
+
+	GetTOTP(ctx context.Context, userID string) (*TOTP, error)
+	SetTOTPSecret(ctx context.Context, userID, sealedSecret string) error
+	EnableTOTP(ctx context.Context, userID, keepSessionID string, step int64, recoveryHashes []string) error
+	UseTOTPStep(ctx context.Context, userID string, step int64) error
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) error
+	DisableTOTP(ctx context.Context, userID, keepSessionID string) error
+
+	CreateAPIKey(ctx context.Context, key *APIKey, keyHash string) error
+	ListAPIKeys(ctx context.Context, userID string) ([]*APIKey, error)
+	DeleteAPIKey(ctx context.Context, userID, id string) error
+	UseAPIKey(ctx context.Context, keyHash string) (*APIKey, error)
 }
 
 type Service interface {
 	Register(ctx context.Context, email, name, password string) (*User, error)
-	Login(ctx context.Context, email string, password string) (*User, string, error)
+	// Login returns an *MFAChallengeError instead of tokens when the
+	// account has MFA enabled; LoginMFA finishes it.
+	Login(ctx context.Context, email string, password string) (*User, *TokenPair, error)
+
+	Refresh(ctx context.Context, refreshToken string) (*TokenPair, error)
+	Logout(ctx context.Context, userID, sessionID string) error
+	LogoutEverywhere(ctx context.Context, userID string) (int64, error)
+	SessionActive(ctx context.Context, userID, sessionID string) (bool, error)
+
+	ForgotPassword(ctx context.Context, email, client string) error
+	ResetPassword(ctx context.Context, token, password string) error
+
+	VerifyEmail(ctx context.Context, token string) error
+	ResendVerification(ctx context.Context, userID string) error
+
+	LoginMFA(ctx context.Context, challenge, code string) (*User, *TokenPair, error)
+	SetupMFA(ctx context.Context, userID string) (*MFASetup, error)
+	ConfirmMFA(ctx context.Context, userID, sessionID, code string) ([]string, error)
+	DisableMFA(ctx context.Context, userID, sessionID, password, code string) error
+
+	// CreateAPIKey returns the new key's details and the key itself, which
+	// is not stored and cannot be shown again.
+	CreateAPIKey(ctx context.Context, userID, name string, scopes []string, expiresAt *time.Time) (*APIKey, string, error)
+	ListAPIKeys(ctx context.Context, userID string) ([]*APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, id string) error
+	CheckAPIKey(ctx context.Context, key string) (*middleware.APIKeyOwner, error)
 }
@@ -0,0 +1,72 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/mail"
+)
+
+// verifyTokenTTL is how long an email verification link works, and
+// verifyTokenTTLText the same in words for emails.
+const (
+	verifyTokenTTL     = 48 * time.Hour
+	verifyTokenTTLText = "48 hours"
+)
+
+// newVerifyLink stores a fresh verification token for userID and returns
+// the link that uses it.
+func (s *service) newVerifyLink(ctx context.Context, userID string) (string, error) {
+	token, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.repo.CreateEmailVerification(ctx, userID, hashToken(token), time.Now().Add(verifyTokenTTL)); err != nil {
+		return "", err
+	}
+
+	return s.appURL + "/auth/verify-email?token=" + url.QueryEscape(token), nil
+}
+
+// VerifyEmail marks the email of the account a verification link was sent
+// to as verified. Notes shared with that email become reachable at once.
+func (s *service) VerifyEmail(ctx context.Context, token string) error {
+	if token == "" {
+		return ErrInvalidVerifyToken
+	}
+
+	return s.repo.VerifyEmail(ctx, hashToken(token))
+}
+
+// ResendVerification mails userID a new verification link. Older links keep
+// working until they expire.
+func (s *service) ResendVerification(ctx context.Context, userID string) error {
+	if userID == "" {
+		return fmt.Errorf("userID is required")
+	}
+
+	u, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if u.EmailVerified {
+		return ErrAlreadyVerified
+	}
+
+	link, err := s.newVerifyLink(ctx, u.Id)
+	if err != nil {
+		return err
+	}
+
+	mail.SendBestEffort(ctx, s.mailer, "verify_email", u.Email, mail.VerifyEmail{
+		Name:       u.Name,
+		VerifyLink: link,
+		ExpiresIn:  verifyTokenTTLText,
+	})
+
+	return nil
+}
@@ -0,0 +1,262 @@
+package workspace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/PRASHANTSWAROOP001/notes-app/internal/middleware"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(svc Service) *Handler {
+	return &Handler{service: svc}
+}
+
+// errorStatus maps the package's sentinel errors to HTTP status codes.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrWorkspaceNotFound), errors.Is(err, ErrUserNotFound),
+		errors.Is(err, ErrMemberNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidName), errors.Is(err, ErrInvalidRole):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrMemberExists), errors.Is(err, ErrLastOwner):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *Handler) CreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	workspace, err := h.service.CreateWorkspace(r.Context(), userId, req.Name)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while creating workspace: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(workspace)
+}
+
+func (h *Handler) ListWorkspaces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	workspaces, err := h.service.ListWorkspaces(r.Context(), userId)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while listing workspaces: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspaces)
+}
+
+func (h *Handler) DeleteWorkspace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	workspaceID := r.URL.Query().Get("id")
+
+	if workspaceID == "" {
+		http.Error(w, "missing workspace id param", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.DeleteWorkspace(r.Context(), workspaceID, userId); err != nil {
+		http.Error(w, fmt.Sprintf("error while deleting workspace: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "workspace deleted successfully",
+	})
+}
+
+func (h *Handler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	workspaceID := r.URL.Query().Get("id")
+
+	if workspaceID == "" {
+		http.Error(w, "missing workspace id param", http.StatusBadRequest)
+		return
+	}
+
+	members, err := h.service.ListMembers(r.Context(), workspaceID, userId)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while listing workspace members: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+func (h *Handler) AddMember(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// role defaults to member when omitted
+	var req struct {
+		ID    string `json:"id"`
+		Email string `json:"email"`
+		Role  Role   `json:"role"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	member, err := h.service.AddMember(r.Context(), req.ID, userId, req.Email, req.Role)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error while adding workspace member: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(member)
+}
+
+func (h *Handler) SetMemberRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ID     string `json:"id"`
+		UserID string `json:"user_id"`
+		Role   Role   `json:"role"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.SetMemberRole(r.Context(), req.ID, userId, req.UserID, req.Role); err != nil {
+		http.Error(w, fmt.Sprintf("error while changing member role: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "member role updated successfully",
+	})
+}
+
+func (h *Handler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r.Context())
+
+	if !ok || userId == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	workspaceID := r.URL.Query().Get("id")
+	memberID := r.URL.Query().Get("user_id")
+
+	if workspaceID == "" || memberID == "" {
+		http.Error(w, "missing params in query", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.RemoveMember(r.Context(), workspaceID, userId, memberID); err != nil {
+		http.Error(w, fmt.Sprintf("error while removing workspace member: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "member removed successfully",
+	})
+}
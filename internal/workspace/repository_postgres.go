@@ -0,0 +1,274 @@
+package workspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresRepository(db *pgxpool.Pool) Repository {
+	return &postgresRepository{db: db}
+}
+
+func (r *postgresRepository) CreateWorkspace(ctx context.Context, name, ownerID string) (*Workspace, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	w := Workspace{Name: name, Role: RoleOwner, MemberCount: 1, CreatedBy: ownerID}
+
+	err = tx.QueryRow(ctx, `
+	INSERT INTO workspaces(name, created_by)
+	VALUES ($1, $2)
+	RETURNING id, created_at
+	`, name, ownerID).Scan(&w.ID, &w.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+	INSERT INTO workspace_members(workspace_id, user_id, role)
+	VALUES ($1, $2, $3)
+	`, w.ID, ownerID, RoleOwner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add workspace owner: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	return &w, nil
+}
+
+func (r *postgresRepository) ListWorkspaces(ctx context.Context, userID string) ([]*Workspace, error) {
+	query := `
+	SELECT w.id, w.name, wm.role,
+	       (SELECT COUNT(*) FROM workspace_members m WHERE m.workspace_id = w.id),
+	       w.created_by, w.created_at
+	FROM workspaces w
+	JOIN workspace_members wm ON wm.workspace_id = w.id AND wm.user_id = $1
+	ORDER BY w.name, w.id
+	`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query workspaces: %w", err)
+	}
+	defer rows.Close()
+
+	workspaces := []*Workspace{}
+
+	for rows.Next() {
+		var w Workspace
+		if err := rows.Scan(&w.ID, &w.Name, &w.Role, &w.MemberCount, &w.CreatedBy, &w.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan workspace row: %w", err)
+		}
+		workspaces = append(workspaces, &w)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return workspaces, nil
+}
+
+// DeleteWorkspace removes the workspace and its memberships. Its notes are
+// kept and fall back to being personal notes of their authors.
+func (r *postgresRepository) DeleteWorkspace(ctx context.Context, workspaceID string) error {
+	cmdTag, err := r.db.Exec(ctx, `DELETE FROM workspaces WHERE id = $1`, workspaceID)
+	if err != nil {
+		return fmt.Errorf("failed to delete workspace: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return ErrWorkspaceNotFound
+	}
+
+	return nil
+}
+
+func (r *postgresRepository) GetMemberRole(ctx context.Context, workspaceID, userID string) (Role, error) {
+	var role Role
+	err := r.db.QueryRow(ctx, `
+	SELECT role FROM workspace_members WHERE workspace_id = $1 AND user_id = $2
+	`, workspaceID, userID).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrWorkspaceNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch workspace role: %w", err)
+	}
+
+	return role, nil
+}
+
+func (r *postgresRepository) ListMembers(ctx context.Context, workspaceID string) ([]*Member, error) {
+	query := `
+	SELECT u.id, u.name, u.email, wm.role, wm.created_at
+	FROM workspace_members wm
+	JOIN users u ON u.id = wm.user_id
+	WHERE wm.workspace_id = $1
+	ORDER BY array_position(ARRAY['owner', 'admin', 'member', 'guest'], wm.role), u.name
+	`
+
+	rows, err := r.db.Query(ctx, query, workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query workspace members: %w", err)
+	}
+	defer rows.Close()
+
+	members := []*Member{}
+
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.UserID, &m.Name, &m.Email, &m.Role, &m.JoinedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan workspace member row: %w", err)
+		}
+		members = append(members, &m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return members, nil
+}
+
+// AddMember adds the registered user with the given email.
+func (r *postgresRepository) AddMember(ctx context.Context, workspaceID, email string, role Role) (*Member, error) {
+	query := `
+	INSERT INTO workspace_members(workspace_id, user_id, role)
+	SELECT $1, u.id, $3
+	FROM users u
+	WHERE u.email = $2
+	RETURNING user_id, role, created_at
+	`
+
+	m := Member{Email: email}
+
+	err := r.db.QueryRow(ctx, query, workspaceID, email, role).Scan(&m.UserID, &m.Role, &m.JoinedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, ErrMemberExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to add workspace member: %w", err)
+	}
+
+	if err := r.db.QueryRow(ctx, `SELECT name FROM users WHERE id = $1`, m.UserID).Scan(&m.Name); err != nil {
+		return nil, fmt.Errorf("failed to fetch member name: %w", err)
+	}
+
+	return &m, nil
+}
+
+// SetMemberRole gives userID role on behalf of callerID. The caller's
+// permission is checked against the locked memberships, so it cannot change
+// between the check and the write.
+func (r *postgresRepository) SetMemberRole(ctx context.Context, workspaceID, callerID, userID string, role Role) error {
+	return r.changeMember(ctx, workspaceID, callerID, userID, role, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+		UPDATE workspace_members SET role = $3 WHERE workspace_id = $1 AND user_id = $2
+		`, workspaceID, userID, role)
+		return err
+	})
+}
+
+// RemoveMember takes userID out of the workspace on behalf of callerID, with
+// the same locking as SetMemberRole.
+func (r *postgresRepository) RemoveMember(ctx context.Context, workspaceID, callerID, userID string) error {
+	return r.changeMember(ctx, workspaceID, callerID, userID, "", func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+		DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2
+		`, workspaceID, userID)
+		return err
+	})
+}
+
+// changeMember runs change against one membership while holding locks on
+// every membership of the workspace. With the rows locked it checks that
+// callerID may move the member to next ("" for removal) and refuses the
+// change if the member is the last owner and would not stay one.
+func (r *postgresRepository) changeMember(ctx context.Context, workspaceID, callerID, userID string, next Role, change func(pgx.Tx) error) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update workspace member: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+	SELECT user_id, role FROM workspace_members WHERE workspace_id = $1 FOR UPDATE
+	`, workspaceID)
+	if err != nil {
+		return fmt.Errorf("failed to update workspace member: %w", err)
+	}
+
+	owners := 0
+	var caller, current Role
+
+	for rows.Next() {
+		var id string
+		var role Role
+		if err := rows.Scan(&id, &role); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan workspace member row: %w", err)
+		}
+		if role == RoleOwner {
+			owners++
+		}
+		if id == callerID {
+			caller = role
+		}
+		if id == userID {
+			current = role
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	if caller == "" {
+		return ErrWorkspaceNotFound
+	}
+
+	if current == "" {
+		if !caller.canManage() {
+			return ErrForbidden
+		}
+		return ErrMemberNotFound
+	}
+
+	if err := authorizeMemberChange(caller, current, next, callerID == userID); err != nil {
+		return err
+	}
+
+	if current == RoleOwner && owners == 1 && next != RoleOwner {
+		return ErrLastOwner
+	}
+
+	if err := change(tx); err != nil {
+		return fmt.Errorf("failed to update workspace member: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to update workspace member: %w", err)
+	}
+
+	return nil
+}
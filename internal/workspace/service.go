@@ -0,0 +1,170 @@
+package workspace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type service struct {
+	repo Repository
+}
+
+func NewService(r Repository) Service {
+	return &service{repo: r}
+}
+
+func (s *service) CreateWorkspace(ctx context.Context, userID, name string) (*Workspace, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("userID is required")
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > 100 {
+		return nil, ErrInvalidName
+	}
+
+	w, err := s.repo.CreateWorkspace(ctx, name, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating workspace: %w", err)
+	}
+
+	return w, nil
+}
+
+func (s *service) ListWorkspaces(ctx context.Context, userID string) ([]*Workspace, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("userID is required")
+	}
+
+	workspaces, err := s.repo.ListWorkspaces(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing workspaces: %w", err)
+	}
+
+	return workspaces, nil
+}
+
+func (s *service) DeleteWorkspace(ctx context.Context, workspaceID, userID string) error {
+	role, err := s.callerRole(ctx, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+
+	if role != RoleOwner {
+		return ErrForbidden
+	}
+
+	if err := s.repo.DeleteWorkspace(ctx, workspaceID); err != nil {
+		return fmt.Errorf("error while deleting workspace: %w", err)
+	}
+
+	return nil
+}
+
+func (s *service) ListMembers(ctx context.Context, workspaceID, userID string) ([]*Member, error) {
+	if _, err := s.callerRole(ctx, workspaceID, userID); err != nil {
+		return nil, err
+	}
+
+	members, err := s.repo.ListMembers(ctx, workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing workspace members: %w", err)
+	}
+
+	return members, nil
+}
+
+func (s *service) AddMember(ctx context.Context, workspaceID, userID, email string, role Role) (*Member, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return nil, fmt.Errorf("empty email cant be provided")
+	}
+
+	if role == "" {
+		role = RoleMember
+	}
+	if !role.IsValid() {
+		return nil, ErrInvalidRole
+	}
+
+	caller, err := s.callerRole(ctx, workspaceID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !caller.canManage() || (role == RoleOwner && caller != RoleOwner) {
+		return nil, ErrForbidden
+	}
+
+	member, err := s.repo.AddMember(ctx, workspaceID, email, role)
+	if err != nil {
+		return nil, fmt.Errorf("error while adding workspace member: %w", err)
+	}
+
+	return member, nil
+}
+
+// SetMemberRole changes a member's role. The rules are in
+// authorizeMemberChange; the repository applies them while holding the
+// membership rows, so concurrent changes cannot both pass on stale roles.
+func (s *service) SetMemberRole(ctx context.Context, workspaceID, userID, memberID string, role Role) error {
+	if memberID == "" {
+		return fmt.Errorf("memberID is required")
+	}
+
+	if !role.IsValid() {
+		return ErrInvalidRole
+	}
+
+	if userID == "" {
+		return fmt.Errorf("userID is required")
+	}
+
+	if workspaceID == "" {
+		return fmt.Errorf("workspaceID is required")
+	}
+
+	if err := s.repo.SetMemberRole(ctx, workspaceID, userID, memberID, role); err != nil {
+		return fmt.Errorf("error while changing member role: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveMember takes a member out of the workspace. Anyone may leave;
+// removing someone else follows the same rules as changing their role.
+func (s *service) RemoveMember(ctx context.Context, workspaceID, userID, memberID string) error {
+	if memberID == "" {
+		return fmt.Errorf("memberID is required")
+	}
+
+	if userID == "" {
+		return fmt.Errorf("userID is required")
+	}
+
+	if workspaceID == "" {
+		return fmt.Errorf("workspaceID is required")
+	}
+
+	if err := s.repo.RemoveMember(ctx, workspaceID, userID, memberID); err != nil {
+		return fmt.Errorf("error while removing workspace member: %w", err)
+	}
+
+	return nil
+}
+
+// callerRole returns the caller's role, or ErrWorkspaceNotFound when they
+// are not a member, so outsiders cannot probe which workspaces exist.
+func (s *service) callerRole(ctx context.Context, workspaceID, userID string) (Role, error) {
+	if userID == "" {
+		return "", fmt.Errorf("userID is required")
+	}
+
+	if workspaceID == "" {
+		return "", fmt.Errorf("workspaceID is required")
+	}
+
+	return s.repo.GetMemberRole(ctx, workspaceID, userID)
+}
@@ -0,0 +1,110 @@
+package workspace
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrWorkspaceNotFound = errors.New("workspace not found or access denied")
+	ErrInvalidName       = errors.New("workspace names must be 1-100 characters")
+	ErrInvalidRole       = errors.New("role must be owner, admin, member or guest")
+	ErrForbidden         = errors.New("your workspace role does not allow this")
+	ErrUserNotFound      = errors.New("no user with that email")
+	ErrMemberExists      = errors.New("user is already a member of this workspace")
+	ErrMemberNotFound    = errors.New("user is not a member of this workspace")
+	ErrLastOwner         = errors.New("a workspace must keep at least one owner")
+)
+
+// Role is what a member may do in a workspace. Owners and admins manage
+// members and have full control over every workspace note; only owners can
+// appoint owners or delete the workspace. Members can read and edit notes,
+// guests can only read them.
+type Role string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+	RoleGuest  Role = "guest"
+)
+
+// Roles lists every workspace role from strongest to weakest.
+var Roles = []Role{RoleOwner, RoleAdmin, RoleMember, RoleGuest}
+
+// IsValid reports whether r is one of Roles.
+func (r Role) IsValid() bool {
+	for _, role := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// canManage reports whether r may add, change and remove members.
+func (r Role) canManage() bool {
+	return r == RoleOwner || r == RoleAdmin
+}
+
+// authorizeMemberChange reports whether a caller with role caller may move a
+// member from role current to next, where next is "" for removing them. self
+// is set when callers change their own membership. Admins manage everyone
+// below owner; only owners can appoint or demote owners; anyone may leave.
+func authorizeMemberChange(caller, current, next Role, self bool) error {
+	if self && next == "" {
+		return nil
+	}
+
+	if !caller.canManage() {
+		return ErrForbidden
+	}
+
+	if caller != RoleOwner && (current == RoleOwner || next == RoleOwner) {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// Workspace is a shared library of notes. Role is the caller's role in it.
+type Workspace struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Role        Role      `json:"role,omitempty"`
+	MemberCount int       `json:"member_count"`
+	CreatedBy   string    `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type Member struct {
+	UserID   string    `json:"user_id"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email"`
+	Role     Role      `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type Repository interface {
+	CreateWorkspace(ctx context.Context, name, ownerID string) (*Workspace, error)
+	ListWorkspaces(ctx context.Context, userID string) ([]*Workspace, error)
+	DeleteWorkspace(ctx context.Context, workspaceID string) error
+
+	GetMemberRole(ctx context.Context, workspaceID, userID string) (Role, error)
+	ListMembers(ctx context.Context, workspaceID string) ([]*Member, error)
+	AddMember(ctx context.Context, workspaceID, email string, role Role) (*Member, error)
+	SetMemberRole(ctx context.Context, workspaceID, callerID, userID string, role Role) error
+	RemoveMember(ctx context.Context, workspaceID, callerID, userID string) error
+}
+
+type Service interface {
+	CreateWorkspace(ctx context.Context, userID, name string) (*Workspace, error)
+	ListWorkspaces(ctx context.Context, userID string) ([]*Workspace, error)
+	DeleteWorkspace(ctx context.Context, workspaceID, userID string) error
+
+	ListMembers(ctx context.Context, workspaceID, userID string) ([]*Member, error)
+	AddMember(ctx context.Context, workspaceID, userID, email string, role Role) (*Member, error)
+	SetMemberRole(ctx context.Context, workspaceID, userID, memberID string, role Role) error
+	RemoveMember(ctx context.Context, workspaceID, userID, memberID string) error
+}
@@ -0,0 +1,19 @@
+-- Every write to a note is snapshotted here so older versions can be
+-- listed, diffed and restored. Rows are never updated.
+CREATE TABLE IF NOT EXISTS note_revisions (
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    note_id    UUID NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    revision   INTEGER NOT NULL,
+    title      TEXT NOT NULL,
+    content    TEXT NOT NULL,
+    public     BOOLEAN NOT NULL,
+    editor_id  UUID NOT NULL REFERENCES users(id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (note_id, revision)
+);
+
+-- Seed revision 1 for notes that existed before history was tracked.
+INSERT INTO note_revisions (note_id, revision, title, content, public, editor_id, created_at)
+SELECT n.id, 1, n.title, n.content, n.public, n.author_id, n.updated_at
+FROM notes n
+WHERE NOT EXISTS (SELECT 1 FROM note_revisions r WHERE r.note_id = n.id);
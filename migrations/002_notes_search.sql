@@ -0,0 +1,11 @@
+-- Weighted full-text index over notes: title matches rank above content.
+ALTER TABLE notes
+    ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(content, '')), 'B')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS notes_search_vector_idx ON notes USING GIN (search_vector);
+
+CREATE INDEX IF NOT EXISTS note_shares_email_idx ON note_shares (email);
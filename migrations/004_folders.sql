@@ -0,0 +1,17 @@
+-- Notebooks: per-user folders that can nest to any depth.
+CREATE TABLE IF NOT EXISTS folders (
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    owner_id   UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    parent_id  UUID REFERENCES folders(id) ON DELETE CASCADE,
+    name       TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS folders_owner_parent_idx ON folders (owner_id, parent_id);
+
+-- NULL folder_id means the note sits at the top level.
+ALTER TABLE notes
+    ADD COLUMN IF NOT EXISTS folder_id UUID REFERENCES folders(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS notes_folder_id_idx ON notes (folder_id);
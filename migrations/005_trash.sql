@@ -0,0 +1,4 @@
+-- Soft delete: a non-NULL deleted_at puts the note in its owner's trash.
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS notes_deleted_at_idx ON notes (deleted_at) WHERE deleted_at IS NOT NULL;
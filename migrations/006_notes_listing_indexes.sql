@@ -0,0 +1,4 @@
+-- Keyset pagination walks these indexes in (sort column, id) order.
+CREATE INDEX IF NOT EXISTS notes_author_created_idx ON notes (author_id, created_at, id);
+CREATE INDEX IF NOT EXISTS notes_author_updated_idx ON notes (author_id, updated_at, id);
+CREATE INDEX IF NOT EXISTS notes_author_title_idx ON notes (author_id, title, id);
@@ -0,0 +1,14 @@
+-- Attachment metadata. The bytes themselves live in the blob store under
+-- storage_key; removing a row does not remove the blob, the application does.
+CREATE TABLE IF NOT EXISTS attachments (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    note_id      UUID NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    uploader_id  UUID NOT NULL REFERENCES users(id),
+    filename     TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size         BIGINT NOT NULL,
+    storage_key  TEXT NOT NULL UNIQUE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS attachments_note_id_idx ON attachments (note_id);
@@ -0,0 +1,2 @@
+-- Bumped on every content write; exposed to clients as the note's ETag.
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
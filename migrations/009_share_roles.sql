@@ -0,0 +1,4 @@
+-- Existing shares were read-only, so they become viewers.
+ALTER TABLE note_shares
+    ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'viewer'
+    CHECK (role IN ('viewer', 'commenter', 'editor'));
@@ -0,0 +1,15 @@
+-- Secret share links. Only a SHA-256 of the token is stored; the token itself
+-- is shown to the owner once, when the link is created.
+CREATE TABLE IF NOT EXISTS share_links (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    note_id     UUID NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    created_by  UUID NOT NULL REFERENCES users(id),
+    token_hash  TEXT NOT NULL UNIQUE,
+    expires_at  TIMESTAMPTZ,
+    max_views   INTEGER CHECK (max_views > 0),
+    view_count  INTEGER NOT NULL DEFAULT 0,
+    revoked_at  TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS share_links_note_id_idx ON share_links (note_id);
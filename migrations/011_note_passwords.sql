@@ -0,0 +1,2 @@
+-- Optional passphrase on public notes, bcrypt hashed. NULL means none.
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS password_hash TEXT;
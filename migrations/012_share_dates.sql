@@ -0,0 +1,4 @@
+-- When each share was granted, for the "shared with me" listing. Shares that
+-- predate this column are stamped with the time of the migration.
+ALTER TABLE note_shares
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
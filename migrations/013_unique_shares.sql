@@ -0,0 +1,11 @@
+-- A note can be shared with an email only once. Collapse existing duplicates,
+-- keeping the strongest role, before enforcing it.
+DELETE FROM note_shares a
+USING note_shares b
+WHERE a.note_id = b.note_id
+  AND a.email = b.email
+  AND a.ctid <> b.ctid
+  AND (array_position(ARRAY['editor', 'commenter', 'viewer'], a.role), a.ctid)
+    > (array_position(ARRAY['editor', 'commenter', 'viewer'], b.role), b.ctid);
+
+CREATE UNIQUE INDEX IF NOT EXISTS note_shares_note_email_key ON note_shares (note_id, email);
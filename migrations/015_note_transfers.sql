@@ -0,0 +1,15 @@
+-- Pending ownership transfers. A row lives until the recipient accepts or
+-- either side cancels; notes proposed together share a batch_id.
+CREATE TABLE IF NOT EXISTS note_transfers (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    batch_id     UUID NOT NULL,
+    note_id      UUID NOT NULL UNIQUE REFERENCES notes(id) ON DELETE CASCADE,
+    from_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    to_user_id   UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    keep_editor  BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS note_transfers_to_user_idx ON note_transfers (to_user_id);
+CREATE INDEX IF NOT EXISTS note_transfers_from_user_idx ON note_transfers (from_user_id);
+CREATE INDEX IF NOT EXISTS note_transfers_batch_idx ON note_transfers (batch_id);
@@ -0,0 +1,19 @@
+-- Slugs used to be unique only by luck (title plus six characters of the
+-- id). Give every duplicate but the oldest the full id before enforcing it.
+UPDATE notes n
+SET slug = n.slug || '-' || n.id::text
+WHERE EXISTS (
+    SELECT 1 FROM notes o
+    WHERE o.slug = n.slug AND (o.created_at, o.id) < (n.created_at, n.id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS notes_slug_key ON notes (slug);
+
+-- Slugs a note has given up, so links using them can still be redirected.
+CREATE TABLE IF NOT EXISTS note_slug_history (
+    slug       TEXT PRIMARY KEY,
+    note_id    UUID NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    retired_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS note_slug_history_note_id_idx ON note_slug_history (note_id);
@@ -0,0 +1,14 @@
+-- One row per read through the public note endpoint. viewer_key is a hash
+-- that tells viewers apart without saying who they are; referrer holds only
+-- the referring host.
+CREATE TABLE IF NOT EXISTS note_views (
+    id            BIGSERIAL PRIMARY KEY,
+    note_id       UUID NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    viewed_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    authenticated BOOLEAN NOT NULL,
+    viewer_key    TEXT NOT NULL,
+    referrer      TEXT,
+    agent_class   TEXT NOT NULL CHECK (agent_class IN ('desktop', 'mobile', 'bot', 'other'))
+);
+
+CREATE INDEX IF NOT EXISTS note_views_note_viewed_idx ON note_views (note_id, viewed_at);